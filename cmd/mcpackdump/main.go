@@ -0,0 +1,139 @@
+// Command mcpackdump prints an mcpack item read from a file or from the
+// standard input.
+//
+// Usage:
+//
+//	mcpackdump [-format tree|json|hex] [-path selector] [-npc] [file]
+//
+// The tree format describes every item with its offset, type, key and
+// value, as mcpack.Dump does. The json format converts the item to JSON.
+// The hex format prints a hex dump of the bytes followed by the tree,
+// whose offsets refer to the dump.
+//
+// The selector is a dot separated list of keys and array indexes, such
+// as "user.tags.0", choosing the item to print. With -npc, the input
+// starts with an npc header, which is skipped.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack"
+	"gitlab.baidu.com/ksarch/gomcpack/npc"
+)
+
+var (
+	format  = flag.String("format", "tree", "output `format`: tree, json or hex")
+	path    = flag.String("path", "", "dot separated `selector` of the item to print")
+	withNpc = flag.Bool("npc", false, "skip the npc header preceding the item")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mcpackdump [flags] [file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "mcpackdump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(name string) error {
+	var r io.Reader = os.Stdin
+	if name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if *withNpc {
+		if data, err = stripHeader(data); err != nil {
+			return err
+		}
+	}
+	if *path != "" {
+		if data, err = selectItem(data, strings.Split(*path, ".")); err != nil {
+			return err
+		}
+	}
+
+	switch *format {
+	case "tree":
+		return mcpack.Dump(os.Stdout, data)
+	case "json":
+		if err := mcpack.ToJSON(data, os.Stdout); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	case "hex":
+		fmt.Print(hex.Dump(data))
+		fmt.Println()
+		return mcpack.Dump(os.Stdout, data)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+// stripHeader returns the body following the npc header in data.
+func stripHeader(data []byte) ([]byte, error) {
+	var h npc.Header
+	if err := h.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	if h.MagicNum != npc.HEADER_MAGICNUM {
+		return nil, fmt.Errorf("bad npc magic number 0x%08x", h.MagicNum)
+	}
+	body := data[npc.HEADER_SIZE:]
+	if int(h.BodyLen) > len(body) {
+		return nil, fmt.Errorf("npc body length %d exceeds the %d bytes available", h.BodyLen, len(body))
+	}
+	return body[:h.BodyLen], nil
+}
+
+// selectItem returns the item reached by following sel from the item in
+// data. An element of sel is an index if it selects from an array and a
+// key otherwise.
+func selectItem(data []byte, sel []string) ([]byte, error) {
+	for i, s := range sel {
+		it, err := mcpack.Get(data)
+		if err != nil {
+			return nil, err
+		}
+		var p interface{} = s
+		if it.Type == mcpack.MCPACKV2_ARRAY {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: array index %q is not a number", strings.Join(sel[:i], "."), s)
+			}
+			p = n
+		}
+		if it, err = mcpack.Get(data, p); err != nil {
+			if err == mcpack.ErrNotFound {
+				err = errors.New(strings.Join(sel[:i+1], ".") + ": not found")
+			}
+			return nil, err
+		}
+		data = it.Raw
+	}
+	return data, nil
+}
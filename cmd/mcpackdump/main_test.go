@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack"
+	"gitlab.baidu.com/ksarch/gomcpack/npc"
+)
+
+func npcFrame(magic, bodyLen uint32, body []byte) []byte {
+	h := npc.Header{MagicNum: magic, BodyLen: bodyLen}
+	b := make([]byte, npc.HEADER_SIZE)
+	h.Marshal(b)
+	return append(b, body...)
+}
+
+func TestStripHeader(t *testing.T) {
+	body := []byte("body")
+	tests := []struct {
+		in   []byte
+		out  []byte
+		fail string
+	}{
+		{npcFrame(npc.HEADER_MAGICNUM, 4, body), body, ""},
+		{npcFrame(npc.HEADER_MAGICNUM, 2, body), body[:2], ""},
+		{npcFrame(npc.HEADER_MAGICNUM, 4, body)[:10], nil, "incomplete header"},
+		{npcFrame(0x12345678, 4, body), nil, "magic number"},
+		{npcFrame(npc.HEADER_MAGICNUM, 5, body), nil, "exceeds"},
+	}
+	for i, tt := range tests {
+		out, err := stripHeader(tt.in)
+		if tt.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.fail) {
+				t.Errorf("#%d: got %v, expect an error containing %q", i, err, tt.fail)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(out, tt.out) {
+			t.Errorf("#%d: got %q, %v, expect %q", i, out, err, tt.out)
+		}
+	}
+}
+
+func TestSelectItem(t *testing.T) {
+	data, err := mcpack.Marshal(map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": "gopher",
+			"tags": []string{"a", "b"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sel  string
+		out  interface{}
+		fail string
+	}{
+		{"user.name", "gopher", ""},
+		{"user.tags.1", "b", ""},
+		{"user.tags.x", nil, `user.tags: array index "x" is not a number`},
+		{"user.tags.5", nil, "user.tags.5: not found"},
+		{"user.age", nil, "user.age: not found"},
+	}
+	for _, tt := range tests {
+		b, err := selectItem(data, strings.Split(tt.sel, "."))
+		if tt.fail != "" {
+			if err == nil || err.Error() != tt.fail {
+				t.Errorf("%s: got %v, expect %q", tt.sel, err, tt.fail)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.sel, err)
+			continue
+		}
+		want, _ := mcpack.Marshal(tt.out)
+		got, _ := mcpack.Get(b)
+		exp, _ := mcpack.Get(want)
+		if got.Type != exp.Type || !bytes.Equal(got.Value, exp.Value) {
+			t.Errorf("%s: got %#v, expect %#v", tt.sel, b, want)
+		}
+	}
+}
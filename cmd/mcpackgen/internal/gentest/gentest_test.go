@@ -0,0 +1,152 @@
+package gentest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+// plainRequest has the fields of Request without its methods, so that
+// it goes through the reflective encoder and decoder.
+type plainRequest Request
+
+func newRequest() Request {
+	count := int32(7)
+	return Request{
+		LogID:  1 << 40,
+		Method: "search",
+		Retry:  -3,
+		Flags:  0x8001,
+		Ratio:  0.5,
+		Score:  -1.25,
+		OK:     true,
+		Status: 2,
+		Count:  &count,
+		Tags:   []string{"a", "bb"},
+		Body:   []byte{0, 1, 2},
+		Token:  "secret",
+		Seq:    42,
+		At:     time.Unix(1500000000, 0).UTC(),
+		Peer:   &Peer{Addr: "10.0.0.1", Port: 8080},
+		Peers:  []Peer{{Addr: "a", Port: 1}, {Addr: "b", Port: 2}},
+		Extra:  map[string]int32{"x": 1},
+		Note:   "n",
+	}
+}
+
+func TestMarshalMatchesReflection(t *testing.T) {
+	for _, r := range []Request{newRequest(), {Note: "only"}, {}} {
+		want, err := mcpack.Marshal(plainRequest(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.MarshalMCPACK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got\n%#v\nexpect\n%#v", got, want)
+		}
+		if viaMarshal, _ := mcpack.Marshal(&r); !bytes.Equal(viaMarshal, want) {
+			t.Errorf("Marshal: got\n%#v\nexpect\n%#v", viaMarshal, want)
+		}
+	}
+}
+
+func TestUnmarshalMatchesReflection(t *testing.T) {
+	r := newRequest()
+	data, _ := r.MarshalMCPACK()
+
+	var want plainRequest
+	if err := mcpack.Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	var got Request
+	if err := got.UnmarshalMCPACK(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plainRequest(got), want) {
+		t.Errorf("got %+v, expect %+v", got, want)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("round trip: got %+v, expect %+v", got, r)
+	}
+}
+
+func TestUnmarshalFoldAndErrors(t *testing.T) {
+	data, _ := mcpack.Marshal(map[string]interface{}{
+		"LOG_ID": int64(5),
+		"retry":  int32(1000),
+		"peer":   "oops",
+	})
+	var got Request
+	err := got.UnmarshalMCPACK(data)
+	if got.LogID != 5 {
+		t.Errorf("LOG_ID: got %d, expect 5", got.LogID)
+	}
+	if _, ok := err.(*mcpack.UnmarshalTypeError); !ok {
+		t.Errorf("got error %v, expect *UnmarshalTypeError", err)
+	}
+
+	var p plainRequest
+	rerr := mcpack.Unmarshal(data, &p)
+	if p.LogID != got.LogID || p.Retry != got.Retry {
+		t.Errorf("reflective decoding differs: %+v", p)
+	}
+	if rerr == nil {
+		t.Error("expected error from reflective decoding")
+	}
+}
+
+func TestDecoderMatchesReflection(t *testing.T) {
+	r := newRequest()
+	full, _ := r.MarshalMCPACK()
+	tests := []struct {
+		name string
+		in   interface{}
+		opts func(*mcpack.Decoder)
+	}{
+		{"fold", map[string]interface{}{"LOG_ID": int64(5), "Method": "m", "peer": map[string]interface{}{"ADDR": "x"}}, nil},
+		{"overflow", map[string]interface{}{"retry": int32(1000), "flags": int32(-1), "note": "n"}, nil},
+		{"bad type", map[string]interface{}{"peer": "oops", "tags": int32(1), "peers": []interface{}{"p"}}, nil},
+		{"null", map[string]interface{}{"count": nil, "peer": nil, "tags": nil}, nil},
+		{"bool int", map[string]interface{}{"ok": int32(1), "retry": true}, nil},
+		{"convert bool int", map[string]interface{}{"ok": int32(1), "retry": true},
+			(*mcpack.Decoder).ConvertBoolInt},
+		{"case sensitive", map[string]interface{}{"LOG_ID": int64(5), "method": "m"},
+			(*mcpack.Decoder).CaseSensitive},
+		{"unknown field", map[string]interface{}{"nope": int32(1), "peer": map[string]interface{}{"what": 1}},
+			(*mcpack.Decoder).DisallowUnknownFields},
+		{"max depth", full, func(dec *mcpack.Decoder) { dec.SetMaxDepth(2) }},
+		{"max elements", full, func(dec *mcpack.Decoder) { dec.SetMaxElements(1) }},
+		{"max alloc", full, func(dec *mcpack.Decoder) { dec.SetMaxAlloc(32) }},
+		{"top-level null", []byte{mcpack.MCPACKV2_NULL, 0, 0}, nil},
+	}
+	for _, tt := range tests {
+		data, ok := tt.in.([]byte)
+		if !ok {
+			var err error
+			if data, err = mcpack.Marshal(tt.in); err != nil {
+				t.Fatal(err)
+			}
+		}
+		decode := func(v interface{}) error {
+			dec := mcpack.NewDecoder(bytes.NewReader(data))
+			if tt.opts != nil {
+				tt.opts(dec)
+			}
+			return dec.Decode(v)
+		}
+		got, want := newRequest(), plainRequest(newRequest())
+		gerr, werr := decode(&got), decode(&want)
+		if !reflect.DeepEqual(plainRequest(got), want) {
+			t.Errorf("%s: got %+v, expect %+v", tt.name, got, want)
+		}
+		if !reflect.DeepEqual(gerr, werr) {
+			t.Errorf("%s: got error %v, expect %v", tt.name, gerr, werr)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Code generated by mcpackgen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack"
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack/mcpackrt"
+)
+
+// MarshalMCPACK implements mcpack.Marshaler.
+func (x Request) MarshalMCPACK() ([]byte, error) {
+	return x.appendMCPACK(nil, "")
+}
+
+func (x Request) appendMCPACK(b []byte, k string) ([]byte, error) {
+	var err error
+	b, off := mcpackrt.BeginObject(b, k)
+	n := 0
+	b = mcpackrt.AppendInt64(b, "log_id", x.LogID)
+	n++
+	b = mcpackrt.AppendString(b, "method", x.Method)
+	n++
+	b = mcpackrt.AppendInt32(b, "retry", int32(x.Retry))
+	n++
+	b = mcpackrt.AppendUint32(b, "flags", uint32(x.Flags))
+	n++
+	b = mcpackrt.AppendFloat32(b, "ratio", x.Ratio)
+	n++
+	b = mcpackrt.AppendFloat64(b, "score", x.Score)
+	n++
+	b = mcpackrt.AppendBool(b, "ok", x.OK)
+	n++
+	b = mcpackrt.AppendInt32(b, "status", int32(x.Status))
+	n++
+	if x.Count == nil {
+		b = mcpackrt.AppendNull(b, "count")
+	} else {
+		b = mcpackrt.AppendInt32(b, "count", (*x.Count))
+	}
+	n++
+	var off1 int
+	b, off1 = mcpackrt.BeginArray(b, "tags")
+	for i2 := range x.Tags {
+		b = mcpackrt.AppendString(b, "", x.Tags[i2])
+	}
+	b = mcpackrt.EndContainer(b, off1, len(x.Tags))
+	n++
+	b = mcpackrt.AppendBinary(b, "body", x.Body)
+	n++
+	b = mcpackrt.AppendBinary(b, "token", []byte(x.Token))
+	n++
+	if b, err = mcpackrt.AppendUint(b, "seq", uint64(x.Seq), mcpack.MCPACKV2_INT32); err != nil {
+		return nil, err
+	}
+	n++
+	b = mcpackrt.AppendDate(b, "at", x.At)
+	n++
+	if x.Peer == nil {
+		b = mcpackrt.AppendNull(b, "peer")
+	} else {
+		if b, err = x.Peer.appendMCPACK(b, "peer"); err != nil {
+			return nil, err
+		}
+	}
+	n++
+	var off3 int
+	b, off3 = mcpackrt.BeginArray(b, "peers")
+	for i4 := range x.Peers {
+		if b, err = x.Peers[i4].appendMCPACK(b, ""); err != nil {
+			return nil, err
+		}
+	}
+	b = mcpackrt.EndContainer(b, off3, len(x.Peers))
+	n++
+	if b, err = mcpackrt.AppendValue(b, "extra", &x.Extra); err != nil {
+		return nil, err
+	}
+	n++
+	if len(x.Note) != 0 {
+		b = mcpackrt.AppendString(b, "note", x.Note)
+		n++
+	}
+	return mcpackrt.EndContainer(b, off, n), err
+}
+
+var mcpackKeysRequest = []string{"log_id", "method", "retry", "flags", "ratio", "score", "ok", "status", "count", "tags", "body", "token", "seq", "at", "peer", "peers", "extra", "note"}
+
+// UnmarshalMCPACK implements mcpack.Unmarshaler.
+func (x *Request) UnmarshalMCPACK(data []byte) error {
+	return mcpack.Unmarshal(data, x)
+}
+
+// DecodeMCPACK decodes the object at hand in d into x. It is called
+// by the mcpack decoder in place of UnmarshalMCPACK.
+func (x *Request) DecodeMCPACK(d mcpackrt.Decoder) {
+	if !d.BeginObject(x) {
+		return
+	}
+	for d.More() {
+		switch d.Field(mcpackKeysRequest) {
+		case 0:
+			d.Value(&x.LogID)
+		case 1:
+			d.Value(&x.Method)
+		case 2:
+			d.Value(&x.Retry)
+		case 3:
+			d.Value(&x.Flags)
+		case 4:
+			d.Value(&x.Ratio)
+		case 5:
+			d.Value(&x.Score)
+		case 6:
+			d.Value(&x.OK)
+		case 7:
+			d.Value(&x.Status)
+		case 8:
+			d.Value(&x.Count)
+		case 9:
+			d.Value(&x.Tags)
+		case 10:
+			d.Value(&x.Body)
+		case 11:
+			d.Value(&x.Token)
+		case 12:
+			d.Value(&x.Seq)
+		case 13:
+			d.Value(&x.At)
+		case 14:
+			d.Value(&x.Peer)
+		case 15:
+			d.Value(&x.Peers)
+		case 16:
+			d.Value(&x.Extra)
+		case 17:
+			d.Value(&x.Note)
+		default:
+			d.Value(nil)
+		}
+	}
+}
+
+// MarshalMCPACK implements mcpack.Marshaler.
+func (x Peer) MarshalMCPACK() ([]byte, error) {
+	return x.appendMCPACK(nil, "")
+}
+
+func (x Peer) appendMCPACK(b []byte, k string) ([]byte, error) {
+	var err error
+	b, off := mcpackrt.BeginObject(b, k)
+	n := 0
+	b = mcpackrt.AppendString(b, "addr", x.Addr)
+	n++
+	b = mcpackrt.AppendUint32(b, "port", uint32(x.Port))
+	n++
+	return mcpackrt.EndContainer(b, off, n), err
+}
+
+var mcpackKeysPeer = []string{"addr", "port"}
+
+// UnmarshalMCPACK implements mcpack.Unmarshaler.
+func (x *Peer) UnmarshalMCPACK(data []byte) error {
+	return mcpack.Unmarshal(data, x)
+}
+
+// DecodeMCPACK decodes the object at hand in d into x. It is called
+// by the mcpack decoder in place of UnmarshalMCPACK.
+func (x *Peer) DecodeMCPACK(d mcpackrt.Decoder) {
+	if !d.BeginObject(x) {
+		return
+	}
+	for d.More() {
+		switch d.Field(mcpackKeysPeer) {
+		case 0:
+			d.Value(&x.Addr)
+		case 1:
+			d.Value(&x.Port)
+		default:
+			d.Value(nil)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Package gentest holds types whose mcpack methods are generated by
+// mcpackgen, to check them against the reflective encoder and decoder.
+package gentest
+
+import "time"
+
+//go:generate go run gitlab.baidu.com/ksarch/gomcpack/cmd/mcpackgen
+
+// Status is a named integer type.
+type Status int32
+
+// Request covers the field types handled by generated code and some
+// that fall back to reflection.
+//
+//mcpack:generate
+type Request struct {
+	LogID   int64            `json:"log_id"`
+	Method  string           `json:"method"`
+	Retry   int8             `json:"retry"`
+	Flags   uint16           `json:"flags"`
+	Ratio   float32          `json:"ratio"`
+	Score   float64          `json:"score"`
+	OK      bool             `json:"ok"`
+	Status  Status           `json:"status"`
+	Count   *int32           `json:"count"`
+	Tags    []string         `json:"tags"`
+	Body    []byte           `json:"body"`
+	Token   string           `mcpack:"token,binary"`
+	Seq     uint64           `mcpack:"seq,int32"`
+	At      time.Time        `mcpack:"at,date"`
+	Peer    *Peer            `json:"peer"`
+	Peers   []Peer           `json:"peers"`
+	Extra   map[string]int32 `json:"extra"`
+	Note    string           `json:"note,omitempty"`
+	Ignored string           `json:"-"`
+
+	internal int
+}
+
+// Peer is nested in Request.
+//
+//mcpack:generate
+type Peer struct {
+	Addr string `json:"addr"`
+	Port uint16 `json:"port"`
+}
@@ -0,0 +1,555 @@
+// Command mcpackgen generates MarshalMCPACK and UnmarshalMCPACK methods
+// for struct types, so that they are encoded and decoded without
+// reflection.
+//
+// Usage:
+//
+//	mcpackgen [-type T,U] [-output file] [dir]
+//
+// mcpackgen reads the package in dir, the current directory by default,
+// and writes the methods of the named types, or of the struct types
+// whose declaration is annotated with a comment line
+//
+//	//mcpack:generate
+//
+// to mcpack_gen.go. It is meant to be run by go generate:
+//
+//	//go:generate mcpackgen
+//
+// The generated MarshalMCPACK writes exactly what mcpack.Marshal writes,
+// honoring the same struct tags. Fields of basic types, pointers and
+// slices of them, and of other generated types are written by generated
+// code. Other fields, and fields whose types have MCPACK, Text or
+// Binary marshaling methods of their own, go through the reflective
+// encoder. The generated DecodeMCPACK switches on the member keys,
+// matching them to fields as mcpack.Unmarshal does, and decodes each
+// member into its field through the mcpack decoder, so that the options
+// and limits of a mcpack.Decoder apply. Embedded struct fields are not
+// supported.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	mcpackPath   = "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+	mcpackrtPath = mcpackPath + "/mcpackrt"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma separated list of type `names`; default annotated types")
+	output    = flag.String("output", "mcpack_gen.go", "output file `name`, relative to dir")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: mcpackgen [flags] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	var names []string
+	if *typeNames != "" {
+		names = strings.Split(*typeNames, ",")
+	}
+	src, err := generate(dir, names, *output)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, *output), src, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mcpackgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// generate returns the source of the methods for the named types, or
+// the annotated ones if names is empty, of the package in dir. The file
+// named output is left out of the package, as it is to be replaced.
+func generate(dir string, names []string, output string) ([]byte, error) {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		if name == output {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if len(names) == 0 {
+		names = annotatedTypes(files)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no types to generate in %s", dir)
+	}
+
+	// The package may not type check until the methods exist, so
+	// errors are ignored and only the declarations are relied upon.
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, fset, files, nil)
+
+	g := &generator{
+		pkg:     pkg,
+		gen:     map[*types.TypeName]bool{},
+		imports: map[string]string{mcpackPath: "mcpack", mcpackrtPath: "mcpackrt"},
+	}
+	var targets []*types.TypeName
+	for _, name := range names {
+		tn, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("type %s not found", name)
+		}
+		if _, ok := tn.Type().Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("%s is not a struct type", name)
+		}
+		g.gen[tn] = true
+		targets = append(targets, tn)
+	}
+	for _, tn := range targets {
+		if err := g.genType(tn); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by mcpackgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", pkg.Name())
+	var paths []string
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Fprintf(&out, "import (\n")
+	for _, path := range paths {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	fmt.Fprintf(&out, ")\n")
+	out.Write(g.buf.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+// annotatedTypes returns the names of the types declared with a
+// //mcpack:generate comment.
+func annotatedTypes(files []*ast.File) []string {
+	var names []string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				if annotated(doc) {
+					names = append(names, ts.Name.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+func annotated(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == "//mcpack:generate" {
+			return true
+		}
+	}
+	return false
+}
+
+// A genField is a struct field as seen by the mcpack encoder.
+type genField struct {
+	name      string // key
+	goName    string
+	typ       types.Type
+	tagged    bool
+	omitEmpty bool
+	wire      string // item type forced by the tag options
+}
+
+// fields returns the encoded fields of st in order, resolving name
+// conflicts as the reflective encoder does.
+func fields(tn *types.TypeName, st *types.Struct) ([]genField, error) {
+	var all []genField
+	for i := 0; i < st.NumFields(); i++ {
+		sf := st.Field(i)
+		if !sf.Exported() {
+			continue
+		}
+		tag := reflect.StructTag(st.Tag(i))
+		s, ok := tag.Lookup("mcpack")
+		if !ok {
+			s = tag.Get("json")
+		}
+		if s == "-" {
+			continue
+		}
+		name, opts := s, ""
+		if i := strings.Index(s, ","); i >= 0 {
+			name, opts = s[:i], s[i+1:]
+		}
+		if !isValidTag(name) {
+			name = ""
+		}
+		ft := sf.Type()
+		if p, ok := ft.(*types.Pointer); ok {
+			ft = p.Elem()
+		}
+		if _, ok := ft.Underlying().(*types.Struct); ok && sf.Anonymous() && name == "" {
+			return nil, fmt.Errorf("%s: embedded struct field %s is not supported", tn.Name(), sf.Name())
+		}
+		f := genField{
+			name:      name,
+			goName:    sf.Name(),
+			typ:       sf.Type(),
+			tagged:    name != "",
+			omitEmpty: hasOption(opts, "omitempty"),
+			wire:      wireOption(opts),
+		}
+		if f.name == "" {
+			f.name = sf.Name()
+		}
+		if len(f.name) > 254 {
+			return nil, fmt.Errorf("%s: key of field %s is too long", tn.Name(), sf.Name())
+		}
+		all = append(all, f)
+	}
+
+	// Of several fields with the same name, only a single tagged one
+	// is kept.
+	byName := map[string][]genField{}
+	for _, f := range all {
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var out []genField
+	for _, f := range all {
+		dups := byName[f.name]
+		if len(dups) == 1 {
+			out = append(out, f)
+			continue
+		}
+		tagged := 0
+		for _, d := range dups {
+			if d.tagged {
+				tagged++
+			}
+		}
+		if tagged == 1 && f.tagged {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:<=>?@[]^_{|}~ ", c):
+		default:
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasOption(opts, name string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+func wireOption(opts string) string {
+	for _, o := range []string{"binary", "int64", "int32", "date"} {
+		if hasOption(opts, o) {
+			return o
+		}
+	}
+	return ""
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	pkg     *types.Package
+	gen     map[*types.TypeName]bool // types being generated
+	imports map[string]string        // path to name
+	tmp     int
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// temp returns a new variable name starting with prefix.
+func (g *generator) temp(prefix string) string {
+	g.tmp++
+	return prefix + strconv.Itoa(g.tmp)
+}
+
+func (g *generator) genType(tn *types.TypeName) error {
+	st := tn.Type().Underlying().(*types.Struct)
+	fs, err := fields(tn, st)
+	if err != nil {
+		return err
+	}
+	name := tn.Name()
+
+	g.printf("\n// MarshalMCPACK implements mcpack.Marshaler.\n")
+	g.printf("func (x %s) MarshalMCPACK() ([]byte, error) {\n", name)
+	g.printf("return x.appendMCPACK(nil, \"\")\n}\n\n")
+	g.printf("func (x %s) appendMCPACK(b []byte, k string) ([]byte, error) {\n", name)
+	g.printf("var err error\n")
+	g.printf("b, off := mcpackrt.BeginObject(b, k)\n")
+	g.printf("n := 0\n")
+	for _, f := range fs {
+		v := "x." + f.goName
+		if f.omitEmpty {
+			if cond := nonEmpty(v, f.typ); cond != "" {
+				g.printf("if %s {\n", cond)
+				g.encode(v, f.typ, strconv.Quote(f.name), f.wire)
+				g.printf("n++\n}\n")
+				continue
+			}
+		}
+		g.encode(v, f.typ, strconv.Quote(f.name), f.wire)
+		g.printf("n++\n")
+	}
+	g.printf("return mcpackrt.EndContainer(b, off, n), err\n}\n\n")
+
+	keys := "mcpackKeys" + name
+	g.printf("var %s = []string{", keys)
+	for _, f := range fs {
+		g.printf("%q,", f.name)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// UnmarshalMCPACK implements mcpack.Unmarshaler.\n")
+	g.printf("func (x *%s) UnmarshalMCPACK(data []byte) error {\n", name)
+	g.printf("return mcpack.Unmarshal(data, x)\n}\n\n")
+	g.printf("// DecodeMCPACK decodes the object at hand in d into x. It is called\n")
+	g.printf("// by the mcpack decoder in place of UnmarshalMCPACK.\n")
+	g.printf("func (x *%s) DecodeMCPACK(d mcpackrt.Decoder) {\n", name)
+	g.printf("if !d.BeginObject(x) {\nreturn\n}\n")
+	g.printf("for d.More() {\n")
+	g.printf("switch d.Field(%s) {\n", keys)
+	for i, f := range fs {
+		g.printf("case %d:\nd.Value(&x.%s)\n", i, f.goName)
+	}
+	g.printf("default:\nd.Value(nil)\n}\n}\n}\n")
+	return nil
+}
+
+// nonEmpty returns the condition under which the value v of type t is
+// not empty for the omitempty option, or "" if it never is.
+func nonEmpty(v string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return v
+		case u.Info()&types.IsString != 0:
+			return "len(" + v + ") != 0"
+		case u.Kind() == types.Uintptr:
+			return ""
+		case u.Info()&(types.IsInteger|types.IsFloat) != 0:
+			return v + " != 0"
+		}
+	case *types.Array, *types.Slice, *types.Map:
+		return "len(" + v + ") != 0"
+	case *types.Pointer, *types.Interface:
+		return v + " != nil"
+	}
+	return ""
+}
+
+// generated reports whether t is one of the types being generated.
+func (g *generator) generated(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	return ok && g.gen[n.Obj()]
+}
+
+// hasMethod reports whether t or *t has the named method.
+func (g *generator) hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, g.pkg, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+// wireApplies reports whether the item type forced by the tag option
+// wire applies to a value of type t, as for newWireEncoder.
+func wireApplies(t types.Type, wire string) bool {
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		return wireApplies(p.Elem(), wire)
+	}
+	switch wire {
+	case "date":
+		n, ok := t.(*types.Named)
+		return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == "time" && n.Obj().Name() == "Time"
+	case "binary":
+		b, ok := t.Underlying().(*types.Basic)
+		return ok && b.Info()&types.IsString != 0
+	case "int32", "int64":
+		b, ok := t.Underlying().(*types.Basic)
+		return ok && b.Info()&types.IsInteger != 0
+	}
+	return false
+}
+
+func isByte(t types.Type) bool {
+	return types.Identical(t, types.Typ[types.Byte])
+}
+
+// encode writes code appending the value v of type t under key k.
+func (g *generator) encode(v string, t types.Type, k string, wire string) {
+	if wire != "" && wireApplies(t, wire) {
+		if p, ok := t.Underlying().(*types.Pointer); ok {
+			g.printf("if %s == nil {\nb = mcpackrt.AppendNull(b, %s)\n} else {\n", v, k)
+			g.encode("(*"+v+")", p.Elem(), k, wire)
+			g.printf("}\n")
+			return
+		}
+		switch wire {
+		case "date":
+			g.printf("b = mcpackrt.AppendDate(b, %s, %s)\n", k, v)
+		case "binary":
+			g.printf("b = mcpackrt.AppendBinary(b, %s, []byte(%s))\n", k, v)
+		default:
+			fn, conv := "AppendInt", "int64"
+			if t.Underlying().(*types.Basic).Info()&types.IsUnsigned != 0 {
+				fn, conv = "AppendUint", "uint64"
+			}
+			g.printf("if b, err = mcpackrt.%s(b, %s, %s(%s), mcpack.MCPACKV2_%s); err != nil {\nreturn nil, err\n}\n",
+				fn, k, conv, v, strings.ToUpper(wire))
+		}
+		return
+	}
+	if g.generated(t) {
+		g.printf("if b, err = %s.appendMCPACK(b, %s); err != nil {\nreturn nil, err\n}\n", ptr(v), k)
+		return
+	}
+	if g.hasMethod(t, "MarshalMCPACK") || g.hasMethod(t, "MarshalText") || g.hasMethod(t, "MarshalBinary") {
+		g.fallbackEncode(v, k)
+		return
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		var fn, conv string
+		switch u.Kind() {
+		case types.Bool:
+			fn, conv = "AppendBool", "bool"
+		case types.Int, types.Int64:
+			fn, conv = "AppendInt64", "int64"
+		case types.Int8, types.Int16, types.Int32:
+			fn, conv = "AppendInt32", "int32"
+		case types.Uint, types.Uint64, types.Uintptr:
+			fn, conv = "AppendUint64", "uint64"
+		case types.Uint8, types.Uint16, types.Uint32:
+			fn, conv = "AppendUint32", "uint32"
+		case types.Float32:
+			fn, conv = "AppendFloat32", "float32"
+		case types.Float64:
+			fn, conv = "AppendFloat64", "float64"
+		case types.String:
+			fn, conv = "AppendString", "string"
+		default:
+			g.fallbackEncode(v, k)
+			return
+		}
+		if !types.Identical(t, types.Universe.Lookup(conv).Type()) {
+			v = conv + "(" + v + ")"
+		}
+		g.printf("b = mcpackrt.%s(b, %s, %s)\n", fn, k, v)
+	case *types.Pointer:
+		g.printf("if %s == nil {\nb = mcpackrt.AppendNull(b, %s)\n} else {\n", v, k)
+		g.encode("(*"+v+")", u.Elem(), k, "")
+		g.printf("}\n")
+	case *types.Slice:
+		if isByte(u.Elem()) {
+			g.printf("b = mcpackrt.AppendBinary(b, %s, %s)\n", k, v)
+			return
+		}
+		off, i := g.temp("off"), g.temp("i")
+		g.printf("var %s int\n", off)
+		g.printf("b, %s = mcpackrt.BeginArray(b, %s)\n", off, k)
+		g.printf("for %s := range %s {\n", i, v)
+		g.encode(v+"["+i+"]", u.Elem(), `""`, "")
+		g.printf("}\n")
+		g.printf("b = mcpackrt.EndContainer(b, %s, len(%s))\n", off, v)
+	default:
+		g.fallbackEncode(v, k)
+	}
+}
+
+func (g *generator) fallbackEncode(v, k string) {
+	g.printf("if b, err = mcpackrt.AppendValue(b, %s, %s); err != nil {\nreturn nil, err\n}\n", k, addr(v))
+}
+
+// addr returns the address of the variable v.
+func addr(v string) string {
+	if p := ptr(v); p != v {
+		return p
+	}
+	return "&" + v
+}
+
+// ptr returns v, or the pointer it is read through if any, for calling
+// methods on v.
+func ptr(v string) string {
+	if strings.HasPrefix(v, "(*") && strings.HasSuffix(v, ")") {
+		return v[2 : len(v)-1]
+	}
+	return v
+}
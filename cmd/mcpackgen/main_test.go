@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestGolden checks that the committed code in internal/gentest is what
+// the generator currently produces.
+func TestGolden(t *testing.T) {
+	dir := filepath.Join("internal", "gentest")
+	got, err := generate(dir, nil, "mcpack_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join(dir, "mcpack_gen.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s; run go generate there", dir)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := filepath.Join("internal", "gentest")
+	for _, names := range [][]string{{"Missing"}, {"Status"}} {
+		if _, err := generate(dir, names, "mcpack_gen.go"); err == nil {
+			t.Errorf("%v: expected error", names)
+		}
+	}
+}
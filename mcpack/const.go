@@ -1,5 +1,9 @@
 package mcpack
 
+import (
+	"fmt"
+)
+
 const (
 	MCPACKV2_INVALID      = 0x00
 	MCPACKV2_OBJECT       = 0x10
@@ -30,3 +34,33 @@ const (
 
 	MAX_SHORT_VITEM_LEN = 255
 )
+
+var typeNames = map[byte]string{
+	MCPACKV2_OBJECT:       "object",
+	MCPACKV2_ARRAY:        "array",
+	MCPACKV2_STRING:       "string",
+	MCPACKV2_SHORT_STRING: "string",
+	MCPACKV2_BINARY:       "binary",
+	MCPACKV2_SHORT_BINARY: "binary",
+	MCPACKV2_INT8:         "int8",
+	MCPACKV2_INT16:        "int16",
+	MCPACKV2_INT32:        "int32",
+	MCPACKV2_INT64:        "int64",
+	MCPACKV2_UINT8:        "uint8",
+	MCPACKV2_UINT16:       "uint16",
+	MCPACKV2_UINT32:       "uint32",
+	MCPACKV2_UINT64:       "uint64",
+	MCPACKV2_BOOL:         "bool",
+	MCPACKV2_FLOAT:        "float",
+	MCPACKV2_DOUBLE:       "double",
+	MCPACKV2_DATE:         "date",
+	MCPACKV2_NULL:         "null",
+}
+
+// typeName returns a short description of the item type typ.
+func typeName(typ byte) string {
+	if name, ok := typeNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("type 0x%02x", typ)
+}
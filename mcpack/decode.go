@@ -2,15 +2,17 @@ package mcpack
 
 import (
 	"bytes"
+	"encoding"
 	"errors"
+	"fmt"
+	"math"
 	"reflect"
 	"runtime"
+	"strconv"
+	"time"
 )
 
-var (
-	errEmptyKey      = errors.New("empty key")
-	errUnexpectedEnd = errors.New("unexpected end")
-)
+var errEmptyKey = errors.New("empty key")
 
 func Unmarshal(data []byte, v interface{}) error {
 	var d decodeState
@@ -18,6 +20,15 @@ func Unmarshal(data []byte, v interface{}) error {
 	return d.unmarshal(v)
 }
 
+// Unmarshaler is the interface implemented by types that can unmarshal
+// an mcpack item of themselves. The item is passed with its key
+// removed, so that it may be decoded on its own. UnmarshalMCPACK must
+// copy the data if it wishes to retain it after returning.
+//
+// Types without UnmarshalMCPACK that implement encoding.TextUnmarshaler
+// are decoded from STRING items with UnmarshalText, and those that
+// implement encoding.BinaryUnmarshaler from BINARY items with
+// UnmarshalBinary. Items of other types are decoded into them as usual.
 type Unmarshaler interface {
 	UnmarshalMCPACK([]byte) error
 }
@@ -27,15 +38,92 @@ type decodeState struct {
 	off        int
 	savedError error
 	tempstr    string
+	path       []pathElem
+	members    []int // members left in the objects being decoded by generated code
+
+	// convertBoolInt accepts bool items into integers and integer
+	// items into bools
+	convertBoolInt bool
+	// disallowUnknownFields fails on object members that match no
+	// struct field
+	disallowUnknownFields bool
+	// caseSensitive matches keys to struct fields only exactly
+	caseSensitive bool
+	// useNumber decodes numeric items into interface{} as Number
+	useNumber bool
+	// types are the DecodeFuncs registered with a Decoder, consulted
+	// before globalTypes, the global ones when decoding started
+	types       map[reflect.Type]DecodeFunc
+	globalTypes map[reflect.Type]DecodeFunc
+
+	// limits on the data, where 0 selects the default: DefaultMaxDepth
+	// for maxDepth and no limit for the others
+	maxDepth    int
+	maxElements int
+	maxAlloc    int
+	depth       int // number of objects and arrays being decoded
+	allocated   int // approximate number of bytes allocated
+}
+
+// DefaultMaxDepth is the depth to which objects and arrays may nest in
+// data being decoded, unless a Decoder sets another limit. It keeps
+// hostile data from exhausting the stack.
+const DefaultMaxDepth = 10000
+
+// pathElem is a step on the path to the item being decoded: an object
+// key, or an array index if index is not negative.
+type pathElem struct {
+	key   []byte
+	index int
 }
 
 func (d *decodeState) init(data []byte) *decodeState {
 	d.data = data
 	d.off = 0
 	d.savedError = nil
+	d.path = d.path[:0]
+	d.members = d.members[:0]
+	d.depth = 0
+	d.allocated = 0
+	d.globalTypes = globalDecodeFuncs()
 	return d
 }
 
+// decodeFunc returns the DecodeFunc registered for t, or nil.
+func (d *decodeState) decodeFunc(t reflect.Type) DecodeFunc {
+	if f, ok := d.types[t]; ok {
+		return f
+	}
+	return d.globalTypes[t]
+}
+
+// enter records that an object or array is being decoded, failing if
+// that nests them deeper than allowed. leave undoes enter.
+func (d *decodeState) enter() {
+	limit := d.maxDepth
+	if limit <= 0 {
+		limit = DefaultMaxDepth
+	}
+	if d.depth++; d.depth > limit {
+		d.error(&DepthError{Limit: limit, Offset: d.off})
+	}
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+// allocate records that n bytes are about to be allocated for the
+// item at d.off, failing if that exceeds the limit.
+func (d *decodeState) allocate(n int) {
+	if d.maxAlloc <= 0 {
+		return
+	}
+	if d.allocated += n; d.allocated > d.maxAlloc {
+		d.error(&AllocError{Limit: d.maxAlloc, Offset: d.off})
+	}
+}
+
 func (d *decodeState) error(err error) {
 	panic(err)
 }
@@ -66,14 +154,15 @@ func (d *decodeState) unmarshal(v interface{}) (err error) {
 		return d.savedError
 	}
 	if d.off != len(d.data) {
-		return errUnexpectedEnd
+		return &SyntaxError{"trailing data after top-level item", d.off}
 	}
 	return nil
 }
 
 // indirect walks down v allocating pointers as needed,
 // until it gets to a non-pointer.
-// if it encounters an Unmarshaler, indirect stops and returns that.
+// if it encounters an Unmarshaler or a value of a registered type,
+// indirect stops and returns an Unmarshaler for it.
 // if decodingNull is true, indirect stops at the last pointer so that
 // it can be set to nil.
 func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler, reflect.Value) {
@@ -88,6 +177,13 @@ func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler,
 	}
 
 	for {
+		// NULL items set pointers to nil even if their type is
+		// registered
+		if f := d.decodeFunc(v.Type()); f != nil && v.CanSet() &&
+			!(v.Kind() == reflect.Ptr && d.data[d.off] == MCPACKV2_NULL) {
+			return registeredUnmarshaler{f, v}, reflect.Value{}
+		}
+
 		// Load value from interface, but only if the result will be
 		// usefully addressable
 		if v.Kind() == reflect.Interface && !v.IsNil() {
@@ -111,9 +207,13 @@ func (d *decodeState) indirect(v reflect.Value, decodingNull bool) (Unmarshaler,
 			if d.data[d.off] == MCPACKV2_NULL {
 				return nil, v
 			}
+			d.allocate(int(v.Type().Elem().Size()))
 			v.Set(reflect.New(v.Type().Elem()))
 		}
 
+		if f := d.decodeFunc(v.Type().Elem()); f != nil {
+			return registeredUnmarshaler{f, v.Elem()}, reflect.Value{}
+		}
 		if v.Type().NumMethod() > 0 {
 			if u, ok := v.Interface().(Unmarshaler); ok {
 				return u, reflect.Value{}
@@ -130,9 +230,16 @@ func (d *decodeState) value(v reflect.Value) {
 		return
 	}
 
+	if d.deleted() {
+		return
+	}
 	u, pv := d.indirect(v, false)
 	if u != nil {
-		if err := u.UnmarshalMCPACK(d.next()); err != nil {
+		if g, ok := u.(generatedDecoder); ok {
+			g.DecodeMCPACK((*genDecoder)(d))
+			return
+		}
+		if err := u.UnmarshalMCPACK(stripKey(d.next())); err != nil {
 			d.error(err)
 		}
 		return
@@ -140,31 +247,36 @@ func (d *decodeState) value(v reflect.Value) {
 
 	v = pv
 
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		if val := d.valueInterface(); val != nil {
+			v.Set(reflect.ValueOf(val))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return
+	}
+
 	switch d.data[d.off] {
 	case MCPACKV2_OBJECT:
 		d.object(v)
 	case MCPACKV2_ARRAY:
 		d.array(v)
-	case MCPACKV2_STRING:
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
 		d.string(v)
-	case MCPACKV2_SHORT_STRING:
-		d.shortString(v)
-	case MCPACKV2_BINARY:
+	case MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY:
 		d.binary(v)
-	case MCPACKV2_SHORT_BINARY:
-		d.shortBinary(v)
-	/*case MCPACKV2_INT8:
+	case MCPACKV2_INT8:
 		d.int8(v)
 	case MCPACKV2_INT16:
-		d.int16(v)*/
+		d.int16(v)
 	case MCPACKV2_INT32:
 		d.int32(v)
 	case MCPACKV2_INT64:
 		d.int64(v)
-	/*case MCPACKV2_UINT8:
+	case MCPACKV2_UINT8:
 		d.uint8(v)
 	case MCPACKV2_UINT16:
-		d.uint16(v)*/
+		d.uint16(v)
 	case MCPACKV2_UINT32:
 		d.uint32(v)
 	case MCPACKV2_UINT64:
@@ -175,205 +287,311 @@ func (d *decodeState) value(v reflect.Value) {
 		d.float(v)
 	case MCPACKV2_DOUBLE:
 		d.double(v)
+	case MCPACKV2_DATE:
+		d.date(v)
 	case MCPACKV2_NULL:
 		d.null(v)
+	default:
+		d.skip()
 	}
 }
 
+// next returns the item at d.off and moves past it.
 func (d *decodeState) next() []byte {
 	start := d.off
-	typ := d.data[d.off]
-	d.off += 1
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1
-	vlen := 0
-	switch typ {
-	case MCPACKV2_OBJECT:
-		vlen = int(Uint32(d.data[d.off:]))
-		d.off += 4
-	case MCPACKV2_ARRAY:
-		vlen = int(Uint32(d.data[d.off:]))
-		d.off += 4
-	case MCPACKV2_STRING:
-		vlen = int(Uint32(d.data[d.off:]))
-		d.off += 4
-	case MCPACKV2_SHORT_STRING:
-		vlen = int(Uint8(d.data[d.off:]))
-		d.off += 1
-	case MCPACKV2_BINARY:
-		vlen = int(Uint32(d.data[d.off:]))
-		d.off += 4
-	case MCPACKV2_SHORT_BINARY:
-		vlen = int(Uint8(d.data[d.off:]))
-		d.off += 1
-	/*case MCPACKV2_INT8:
-		vlen = 1
-	case MCPACKV2_INT16:
-		vlen = 2*/
-	case MCPACKV2_INT32:
-		vlen = 4
-	case MCPACKV2_INT64:
-		vlen = 8
-	/*case MCPACKV2_UINT8:
-		vlen = 1
-	case MCPACKV2_UINT16:
-		vlen = 2*/
-	case MCPACKV2_UINT32:
-		vlen = 4
-	case MCPACKV2_UINT64:
-		vlen = 8
-	case MCPACKV2_BOOL:
-		vlen = 1
-	case MCPACKV2_FLOAT:
-		vlen = 4
-	case MCPACKV2_DOUBLE:
-		vlen = 8
-	case MCPACKV2_DATE:
-		// FIXME
-	case MCPACKV2_NULL:
-		vlen = 1
+	_, _, vlen, voff, err := itemHeader(d.data, d.off)
+	if err != nil {
+		d.error(err)
 	}
-	d.off += klen + vlen
+	d.off = voff + vlen
 	return d.data[start:d.off]
 }
 
-// type(1) | name length(1) | content length (4)
-// | raw name bytes | 0x00 | content bytes | 0x00
-func (d *decodeState) string(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
-
-	d.off += klen // name and 0x00
-
-	val := string(d.data[d.off : d.off+vlen-1])
-	d.off += vlen // value and 0x00
-
-	v.SetString(val)
+// skip moves past the item at d.off, which must be of a known type.
+func (d *decodeState) skip() {
+	if !validType(d.data[d.off]) {
+		d.error(&SyntaxError{fmt.Sprintf("invalid item type 0x%02x", d.data[d.off]), d.off})
+	}
+	d.next()
 }
 
-func (d *decodeState) stringInterface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
-
-	d.off += klen // name and 0x00
-
-	val := string(d.data[d.off : d.off+vlen-1])
-	d.off += vlen // value and 0x00
-
-	return val
+// deleted reports whether the item at d.off has been deleted in place,
+// moving past it if so.
+func (d *decodeState) deleted() bool {
+	d.need(1)
+	if !isDeleted(d.data[d.off]) {
+		return false
+	}
+	d.next()
+	return true
 }
 
-// type(1) | name length(1) | content length(1) | raw name bytes |
-// 0x00 | content bytes | 0x00
-func (d *decodeState) shortString(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // content length
-
-	d.off += klen // name and 0x00
-
-	val := string(d.data[d.off : d.off+vlen-1])
-	d.off += vlen // value and 0x00
-
-	v.SetString(val)
+// need checks that at least n more bytes are available at d.off.
+func (d *decodeState) need(n int) {
+	if n > len(d.data)-d.off {
+		d.error(&SyntaxError{"unexpected end of data", d.off})
+	}
 }
 
-func (d *decodeState) shortStringInterface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // content length
+// header parses the header of the item at d.off, checking that the
+// whole item lies within the data, and moves d.off to the item content.
+// It returns the item type and the content length.
+func (d *decodeState) header() (typ byte, vlen int) {
+	typ, _, vlen, voff, err := itemHeader(d.data, d.off)
+	if err != nil {
+		d.error(err)
+	}
+	d.off = voff
+	return typ, vlen
+}
 
-	d.off += klen // name and 0x00
+// count reads the member number of an object or array. Every member
+// takes at least 3 bytes, which bounds the number a valid item may claim.
+func (d *decodeState) count() int {
+	d.need(4)
+	n := int(Uint32(d.data[d.off:]))
+	if n > (len(d.data)-d.off-4)/3 {
+		d.error(&SyntaxError{fmt.Sprintf("member number %d exceeds data", n), d.off})
+	}
+	if d.maxElements > 0 && n > d.maxElements {
+		d.error(&ElementsError{Count: n, Limit: d.maxElements, Offset: d.off})
+	}
+	d.off += 4 // member number
+	return n
+}
 
+// stringValue reads the content of a string item of length vlen,
+// dropping its trailing 0x00.
+func (d *decodeState) stringValue(vlen int) string {
+	if vlen == 0 || d.data[d.off+vlen-1] != 0 {
+		d.error(&SyntaxError{"string not terminated by 0x00", d.off})
+	}
+	d.allocate(vlen - 1)
 	val := string(d.data[d.off : d.off+vlen-1])
 	d.off += vlen // value and 0x00
-
 	return val
 }
 
-// type(1) | name length(1) | content length(4) | raw name bytes |
-// 0x00 | content bytes
-func (d *decodeState) binary(v reflect.Value) {
-	d.off += 1 //type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
+// typeError records an UnmarshalTypeError for the item at start, which
+// cannot be stored in v. Decoding goes on with the next item.
+func (d *decodeState) typeError(v reflect.Value, start int) {
+	d.saveError(&UnmarshalTypeError{
+		Value:  typeName(d.data[start]),
+		Type:   v.Type(),
+		Offset: start,
+		Field:  d.fieldPath(),
+	})
+}
 
-	d.off += klen // name and 0x00
+// fieldPath returns the dotted path of the item being decoded, such as
+// "user.tags[3]".
+func (d *decodeState) fieldPath() string {
+	var b []byte
+	for _, p := range d.path {
+		if p.index >= 0 {
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(p.index), 10)
+			b = append(b, ']')
+			continue
+		}
+		if len(b) > 0 {
+			b = append(b, '.')
+		}
+		b = append(b, p.key...)
+	}
+	return string(b)
+}
 
-	val := d.data[d.off : d.off+vlen]
-	d.off += vlen // value
+// overflowError records that the numeric item at start does not fit in
+// v. Decoding goes on with the next item.
+func (d *decodeState) overflowError(v reflect.Value, start int, val interface{}) {
+	d.saveError(&UnmarshalTypeError{
+		Value:  fmt.Sprintf("%s %v", typeName(d.data[start]), val),
+		Type:   v.Type(),
+		Offset: start,
+		Field:  d.fieldPath(),
+	})
+}
 
-	v.SetBytes(val)
+// storeInt stores a signed integer item into v, which may be of any
+// numeric kind, or of bool kind if integers convert to bools.
+func (d *decodeState) storeInt(v reflect.Value, n int64, start int) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			d.overflowError(v, start, n)
+			return
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			d.overflowError(v, start, n)
+			return
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+	case reflect.Bool:
+		if !d.convertBoolInt {
+			d.typeError(v, start)
+			return
+		}
+		v.SetBool(n != 0)
+	default:
+		d.typeError(v, start)
+	}
 }
 
-func (d *decodeState) binaryInterface() interface{} {
-	d.off += 1 //type
+// storeUint stores an unsigned integer item into v, which may be of
+// any numeric kind, or of bool kind if integers convert to bools.
+func (d *decodeState) storeUint(v reflect.Value, n uint64, start int) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n > math.MaxInt64 || v.OverflowInt(int64(n)) {
+			d.overflowError(v, start, n)
+			return
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.OverflowUint(n) {
+			d.overflowError(v, start, n)
+			return
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+	case reflect.Bool:
+		if !d.convertBoolInt {
+			d.typeError(v, start)
+			return
+		}
+		v.SetBool(n != 0)
+	default:
+		d.typeError(v, start)
+	}
+}
 
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
+// storeFloat stores a floating point item into v, which may be of any
+// numeric kind. Only integral values are stored into integers.
+func (d *decodeState) storeFloat(v reflect.Value, f float64, start int) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if v.OverflowFloat(f) {
+			d.overflowError(v, start, f)
+			return
+		}
+		v.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// -2^63 is exact in float64, 2^63 is the first value out of range
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= -math.MinInt64 || v.OverflowInt(int64(f)) {
+			d.overflowError(v, start, f)
+			return
+		}
+		v.SetInt(int64(f))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if f != math.Trunc(f) || f < 0 || f >= 2*-math.MinInt64 || v.OverflowUint(uint64(f)) {
+			d.overflowError(v, start, f)
+			return
+		}
+		v.SetUint(uint64(f))
+	default:
+		d.typeError(v, start)
+	}
+}
 
-	vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
+// storeBool stores a bool item into v, which may also be of integer
+// kind if bools convert to integers.
+func (d *decodeState) storeBool(v reflect.Value, b bool, start int) {
+	var n uint64
+	if b {
+		n = 1
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !d.convertBoolInt {
+			d.typeError(v, start)
+			return
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !d.convertBoolInt {
+			d.typeError(v, start)
+			return
+		}
+		v.SetUint(n)
+	default:
+		d.typeError(v, start)
+	}
+}
 
-	d.off += klen // name and 0x00
+func isByteSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
 
-	val := d.data[d.off : d.off+vlen]
-	d.off += vlen // value
+// type(1) | name length(1) | content length(1/4) | raw name bytes |
+// 0x00 | content bytes | 0x00
+func (d *decodeState) string(v reflect.Value) {
+	start := d.off
+	_, vlen := d.header()
+	if u, ok := textUnmarshaler(v); ok {
+		if vlen == 0 || d.data[d.off+vlen-1] != 0 {
+			d.error(&SyntaxError{"string not terminated by 0x00", d.off})
+		}
+		if err := u.UnmarshalText(d.data[d.off : d.off+vlen-1]); err != nil {
+			d.saveError(err)
+		}
+		d.off += vlen
+		return
+	}
+	val := d.stringValue(vlen)
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(val)
+	case isByteSlice(v.Type()):
+		d.allocate(len(val))
+		v.SetBytes([]byte(val))
+	default:
+		d.typeError(v, start)
+	}
+}
 
-	return val
+func (d *decodeState) stringInterface() interface{} {
+	_, vlen := d.header()
+	return d.stringValue(vlen)
 }
 
-// type(1) | name length(1) | content length(1) | raw name bytes |
+// type(1) | name length(1) | content length(1/4) | raw name bytes |
 // 0x00 | content bytes
-func (d *decodeState) shortBinary(v reflect.Value) {
-	d.off += 1 //type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	vlen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // content length
-
-	d.off += klen // name and 0x00
+func (d *decodeState) binary(v reflect.Value) {
+	start := d.off
+	_, vlen := d.header()
 
 	val := d.data[d.off : d.off+vlen]
 	d.off += vlen // value
 
-	v.SetBytes(val)
-}
-
-func (d *decodeState) shortBinaryInterface() interface{} {
-	d.off += 1 //type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
+	if u, ok := binaryUnmarshaler(v); ok {
+		if err := u.UnmarshalBinary(val); err != nil {
+			d.saveError(err)
+		}
+		return
+	}
 
-	vlen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // content length
+	switch {
+	case isByteSlice(v.Type()):
+		v.SetBytes(val)
+	case v.Kind() == reflect.String:
+		d.allocate(len(val))
+		v.SetString(string(val))
+	default:
+		d.typeError(v, start)
+	}
+}
 
-	d.off += klen // name and 0x00
+func (d *decodeState) binaryInterface() interface{} {
+	_, vlen := d.header()
 
 	val := d.data[d.off : d.off+vlen]
 	d.off += vlen // value
@@ -381,29 +599,19 @@ func (d *decodeState) shortBinaryInterface() interface{} {
 	return val
 }
 
-/*// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1)
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1)
 func (d *decodeState) int8(v reflect.Value) {
-	d.off += 1 // type
-
-    klen := int(Uint8(d.data[d.off:]))
-    d.off += 1 // name length
-
-    d.off += klen
+	start := d.off
+	d.header()
 
-    val := Int8(d.data[d.off:])
-    d.off += 1 //value
+	val := Int8(d.data[d.off:])
+	d.off += 1 // value
 
-    v.SetInt(int64(val))
+	d.storeInt(v, int64(val), start)
 }
 
 func (d *decodeState) int8Interface() interface{} {
-	// unsupported in libmcpack, int32 employed
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Int8(d.data[d.off:])
 	d.off += 1 // value
@@ -413,26 +621,17 @@ func (d *decodeState) int8Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1)
 func (d *decodeState) uint8(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Uint8(d.data[d.off:])
 	d.off += 1 // value
 
-	v.SetUint(uint64(val))
+	d.storeUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint8Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Uint8(d.data[d.off:])
 	d.off += 1 // value
@@ -442,28 +641,17 @@ func (d *decodeState) uint8Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(2)
 func (d *decodeState) int16(v reflect.Value) {
-	d.off += 1 // type
-
-		klen := int(Uint8(d.data[d.off:]))
-		d.off += 1 // name length
-
-		d.off += klen
+	start := d.off
+	d.header()
 
-		val := Int16(d.data[d.off:])
-		//d.off += 2 // value
-	    // unsupported in libmcpack, int32 employed
-	    d.off += 4
+	val := Int16(d.data[d.off:])
+	d.off += 2 // value
 
-		v.SetInt(int64(val))
+	d.storeInt(v, int64(val), start)
 }
 
 func (d *decodeState) int16Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Int16(d.data[d.off:])
 	d.off += 2 // value
@@ -473,57 +661,37 @@ func (d *decodeState) int16Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(2)
 func (d *decodeState) uint16(v reflect.Value) {
-	d.off += 1 // type
-
-		klen := int(Uint8(d.data[d.off:]))
-		d.off += 1 // name length
-
-		d.off += klen
+	start := d.off
+	d.header()
 
-		val := Uint16(d.data[d.off:])
-		//d.off += 2 // value
-	    // unsupported in libmcpack, int32 employed
-	    d.off += 4
+	val := Uint16(d.data[d.off:])
+	d.off += 2 // value
 
-		v.SetUint(uint64(val))
+	d.storeUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint16Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Uint16(d.data[d.off:])
 	d.off += 2 // value
 
 	return val
-}*/
+}
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(4)
 func (d *decodeState) int32(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Int32(d.data[d.off:])
 	d.off += 4 // value
 
-	v.SetInt(int64(val))
+	d.storeInt(v, int64(val), start)
 }
 
 func (d *decodeState) int32Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Int32(d.data[d.off:])
 	d.off += 4 // value
@@ -533,26 +701,17 @@ func (d *decodeState) int32Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(4)
 func (d *decodeState) uint32(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Uint32(d.data[d.off:])
 	d.off += 4 // value
 
-	v.SetUint(uint64(val))
+	d.storeUint(v, uint64(val), start)
 }
 
 func (d *decodeState) uint32Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Uint32(d.data[d.off:])
 	d.off += 4 // value
@@ -562,26 +721,17 @@ func (d *decodeState) uint32Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) int64(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Int64(d.data[d.off:])
 	d.off += 8 // value
 
-	v.SetInt(val)
+	d.storeInt(v, val, start)
 }
 
 func (d *decodeState) int64Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Int64(d.data[d.off:])
 	d.off += 8 // value
@@ -591,26 +741,17 @@ func (d *decodeState) int64Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) uint64(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Uint64(d.data[d.off:])
 	d.off += 8 // value
 
-	v.SetUint(val)
+	d.storeUint(v, val, start)
 }
 
 func (d *decodeState) uint64Interface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Uint64(d.data[d.off:])
 	d.off += 8 // value
@@ -620,12 +761,7 @@ func (d *decodeState) uint64Interface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | 0x00
 func (d *decodeState) null(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	d.off += 1 // value
 
@@ -634,12 +770,7 @@ func (d *decodeState) null(v reflect.Value) {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | 0x00
 func (d *decodeState) nullInterface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	d.off += 1 // value
 
@@ -648,30 +779,17 @@ func (d *decodeState) nullInterface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | 0x00/0x01
 func (d *decodeState) bool(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := d.data[d.off]
 	d.off += 1
 
-	if val == 0 {
-		v.SetBool(false)
-	} else {
-		v.SetBool(true)
-	}
+	d.storeBool(v, val != 0, start)
 }
 
 func (d *decodeState) boolInterface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := d.data[d.off]
 	d.off += 1
@@ -685,26 +803,17 @@ func (d *decodeState) boolInterface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(4)
 func (d *decodeState) float(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	start := d.off
+	d.header()
 
 	val := Float32(d.data[d.off:])
 	d.off += 4
 
-	v.SetFloat(float64(val))
+	d.storeFloat(v, float64(val), start)
 }
 
 func (d *decodeState) floatInterface() interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	d.off += klen
+	d.header()
 
 	val := Float32(d.data[d.off:])
 	d.off += 4
@@ -714,59 +823,89 @@ func (d *decodeState) floatInterface() interface{} {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
 func (d *decodeState) double(v reflect.Value) {
-	d.off += 1 // type
+	start := d.off
+	d.header()
+
+	val := Float64(d.data[d.off:])
+	d.off += 8
 
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
+	d.storeFloat(v, val, start)
+}
 
-	d.off += klen
+func (d *decodeState) doubleInterface() interface{} {
+	d.header()
 
 	val := Float64(d.data[d.off:])
 	d.off += 8
 
-	v.SetFloat(val)
+	return val
 }
 
-func (d *decodeState) doubleInterface() interface{} {
-	d.off += 1 // type
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(1/2/4/8)
+func (d *decodeState) numberInterface() interface{} {
+	typ, vlen := d.header()
+
+	n := Number{typ: typ}
+	d.off += copy(n.val[:], d.data[d.off:d.off+vlen])
 
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
+	return n
+}
 
-	d.off += klen
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
+func (d *decodeState) date(v reflect.Value) {
+	start := d.off
+	d.header()
 
-	val := Float64(d.data[d.off:])
+	val := Int64(d.data[d.off:])
 	d.off += 8
 
-	return val
+	switch {
+	case v.Type() == timeType:
+		v.Set(reflect.ValueOf(time.Unix(val, 0).UTC()))
+	case v.Kind() == reflect.Int64:
+		v.SetInt(val)
+	default:
+		d.typeError(v, start)
+	}
+}
+
+func (d *decodeState) dateInterface() interface{} {
+	d.header()
+
+	val := Int64(d.data[d.off:])
+	d.off += 8
+
+	return time.Unix(val, 0).UTC()
 }
 
 func (d *decodeState) valueInterface() interface{} {
+	if d.deleted() {
+		return nil
+	}
+	if d.useNumber && isNumber(d.data[d.off]) {
+		return d.numberInterface()
+	}
 	switch d.data[d.off] {
 	case MCPACKV2_OBJECT:
 		return d.objectInterface()
 	case MCPACKV2_ARRAY:
 		return d.arrayInterface()
-	case MCPACKV2_STRING:
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
 		return d.stringInterface()
-	case MCPACKV2_SHORT_STRING:
-		return d.shortStringInterface()
-	case MCPACKV2_BINARY:
+	case MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY:
 		return d.binaryInterface()
-	case MCPACKV2_SHORT_BINARY:
-		return d.shortBinaryInterface()
-	/*case MCPACKV2_INT8:
+	case MCPACKV2_INT8:
 		return d.int8Interface()
 	case MCPACKV2_INT16:
-		return d.int16Interface()*/
+		return d.int16Interface()
 	case MCPACKV2_INT32:
 		return d.int32Interface()
 	case MCPACKV2_INT64:
 		return d.int64Interface()
-	/*case MCPACKV2_UINT8:
+	case MCPACKV2_UINT8:
 		return d.uint8Interface()
 	case MCPACKV2_UINT16:
-		return d.uint16Interface()*/
+		return d.uint16Interface()
 	case MCPACKV2_UINT32:
 		return d.uint32Interface()
 	case MCPACKV2_UINT64:
@@ -777,9 +916,12 @@ func (d *decodeState) valueInterface() interface{} {
 		return d.floatInterface()
 	case MCPACKV2_DOUBLE:
 		return d.doubleInterface()
+	case MCPACKV2_DATE:
+		return d.dateInterface()
 	case MCPACKV2_NULL:
 		return d.nullInterface()
 	}
+	d.skip()
 	return nil
 }
 
@@ -791,37 +933,50 @@ func (d *decodeState) object(v reflect.Value) {
 		return
 	}
 
-	// make map
-	if v.Kind() == reflect.Map && v.IsNil() {
-		v.Set(reflect.MakeMap(v.Type()))
+	switch v.Kind() {
+	case reflect.Map:
+		if !isUnmarshalKeyType(v.Type().Key()) {
+			start := d.off
+			d.next()
+			d.typeError(v, start)
+			return
+		}
+		// make map
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case reflect.Struct:
+	default:
+		start := d.off
+		d.next()
+		d.typeError(v, start)
+		return
 	}
 
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	// vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
-
-	d.off += klen // name and 0x00
-
-	n := int(Uint32(d.data[d.off:]))
-	d.off += 4 // member number
+	d.enter()
+	d.header()
+	n := d.count()
 
 	var mapElem reflect.Value
 	for i := 0; i < n; i++ {
+		if d.deleted() {
+			continue
+		}
 		subk := d.key()
-		var subv reflect.Value
+		var subv, kv reflect.Value
+		unknown := false
 
 		if v.Kind() == reflect.Map {
-			elemType := v.Type().Elem()
-			if !mapElem.IsValid() {
-				mapElem = reflect.New(elemType).Elem()
-			} else {
-				mapElem.Set(reflect.Zero(elemType))
+			// members with keys that do not parse are skipped
+			if kv = d.mapKey(v.Type().Key(), subk); kv.IsValid() {
+				elemType := v.Type().Elem()
+				if !mapElem.IsValid() {
+					mapElem = reflect.New(elemType).Elem()
+				} else {
+					mapElem.Set(reflect.Zero(elemType))
+				}
+				subv = mapElem
 			}
-			subv = mapElem
 		} else {
 			var f *field
 			fields := cachedTypeFields(v.Type())
@@ -831,16 +986,18 @@ func (d *decodeState) object(v reflect.Value) {
 					f = ff
 					break
 				}
-				if f == nil && ff.equalFold(ff.nameBytes, subk) {
+				if f == nil && !d.caseSensitive && ff.equalFold(ff.nameBytes, subk) {
 					f = ff
 				}
 			}
+			unknown = f == nil
 			if f != nil {
 				subv = v
 				for _, i := range f.index {
 					if subv.Kind() == reflect.Ptr {
 						if subv.IsNil() {
-							subv.Set(reflect.New(subv.Type()).Elem())
+							d.allocate(int(subv.Type().Elem().Size()))
+							subv.Set(reflect.New(subv.Type().Elem()))
 						}
 						subv = subv.Elem()
 					}
@@ -849,126 +1006,217 @@ func (d *decodeState) object(v reflect.Value) {
 			}
 		}
 
+		d.path = append(d.path, pathElem{key: subk, index: -1})
+		if unknown && d.disallowUnknownFields {
+			d.saveError(&UnknownFieldError{Field: d.fieldPath(), Offset: d.off})
+		}
 		d.value(subv)
+		d.path = d.path[:len(d.path)-1]
 
 		// Write value back to map
-		if v.Kind() == reflect.Map {
-			kv := reflect.ValueOf(subk).Convert(v.Type().Key())
+		if kv.IsValid() {
+			d.allocate(len(subk) + int(subv.Type().Size()))
 			v.SetMapIndex(kv, subv)
 		}
 	}
+	d.leave()
 }
 
-func (d *decodeState) objectInterface() map[string]interface{} {
-	d.off += 1 // type
+var (
+	textUnmarshalerType   = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf(new(encoding.BinaryUnmarshaler)).Elem()
+)
+
+// textUnmarshaler returns the TextUnmarshaler of the addressable v, if
+// its pointer type has one.
+func textUnmarshaler(v reflect.Value) (encoding.TextUnmarshaler, bool) {
+	if !v.CanAddr() || !v.Addr().Type().Implements(textUnmarshalerType) {
+		return nil, false
+	}
+	return v.Addr().Interface().(encoding.TextUnmarshaler), true
+}
+
+// binaryUnmarshaler is textUnmarshaler for encoding.BinaryUnmarshaler.
+func binaryUnmarshaler(v reflect.Value) (encoding.BinaryUnmarshaler, bool) {
+	if !v.CanAddr() || !v.Addr().Type().Implements(binaryUnmarshalerType) {
+		return nil, false
+	}
+	return v.Addr().Interface().(encoding.BinaryUnmarshaler), true
+}
 
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
+// isUnmarshalKeyType reports whether mapKey can parse the keys of a map
+// with key type t: strings, integers and types whose pointer implements
+// encoding.TextUnmarshaler.
+func isUnmarshalKeyType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
 
-	// vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 // content length
+// mapKey returns the map key of type kt for the item key k, parsing it
+// with UnmarshalText or as a decimal integer. If k cannot be parsed, the
+// error is recorded and the returned value is invalid.
+func (d *decodeState) mapKey(kt reflect.Type, k []byte) reflect.Value {
+	if reflect.PtrTo(kt).Implements(textUnmarshalerType) {
+		kv := reflect.New(kt)
+		if err := kv.Interface().(encoding.TextUnmarshaler).UnmarshalText(k); err != nil {
+			d.saveError(err)
+			return reflect.Value{}
+		}
+		return kv.Elem()
+	}
+	kv := reflect.New(kt).Elem()
+	switch kt.Kind() {
+	case reflect.String:
+		kv.SetString(string(k))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(k), 10, 64)
+		if err != nil || kv.OverflowInt(n) {
+			d.keyError(kt, k)
+			return reflect.Value{}
+		}
+		kv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(string(k), 10, 64)
+		if err != nil || kv.OverflowUint(n) {
+			d.keyError(kt, k)
+			return reflect.Value{}
+		}
+		kv.SetUint(n)
+	}
+	return kv
+}
 
-	d.off += klen // name and 0x00
+// keyError records that the key k does not parse as a map key of type
+// kt. Decoding goes on with the next member.
+func (d *decodeState) keyError(kt reflect.Type, k []byte) {
+	d.saveError(&UnmarshalTypeError{
+		Value:  "key " + strconv.Quote(string(k)),
+		Type:   kt,
+		Offset: d.off,
+		Field:  d.fieldPath(),
+	})
+}
 
-	n := int(Uint32(d.data[d.off:]))
-	d.off += 4 // member number
+func (d *decodeState) objectInterface() map[string]interface{} {
+	d.enter()
+	d.header()
+	n := d.count()
 
 	m := make(map[string]interface{})
 	for i := 0; i < n; i++ {
+		if d.deleted() {
+			continue
+		}
 		subk := d.key()
+		d.allocate(len(subk) + int(interfaceSize))
 		m[string(subk)] = d.valueInterface()
 	}
 
+	d.leave()
 	return m
 }
 
+// interfaceSize is the size of an interface{} value.
+var interfaceSize = reflect.TypeOf(new(interface{})).Elem().Size()
+
 //FIXME: fix when v is invalid
 // type(1) | name length(1) | item size(4) | raw name bytes | 0x00
 // | element number(4) | element1 | ... | elementN
 func (d *decodeState) array(v reflect.Value) {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	// vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 //  content length
-
-	//var key string
-	d.off += klen
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(d.arrayInterface()))
+			return
+		}
+		fallthrough
+	default:
+		start := d.off
+		d.next()
+		d.typeError(v, start)
+		return
+	case reflect.Slice, reflect.Array:
+	}
 
-	n := int(Uint32(d.data[d.off:]))
-	d.off += 4 // member number
+	d.enter()
+	d.header()
+	n := d.count()
 
 	if v.Kind() == reflect.Slice {
 		if n > v.Cap() {
+			d.allocate(n * int(v.Type().Elem().Size()))
 			newv := reflect.MakeSlice(v.Type(), n, n)
 			v.Set(newv)
 		}
 		v.SetLen(n)
 	}
 
+	// deleted elements leave no hole, so j counts the live ones
+	j := 0
 	for i := 0; i < n; i++ {
-		if i < v.Len() {
-			d.value(v.Index(i))
+		if d.deleted() {
+			continue
+		}
+		d.path = append(d.path, pathElem{index: j})
+		if j < v.Len() {
+			d.value(v.Index(j))
 		} else {
 			d.value(reflect.Value{})
 		}
+		d.path = d.path[:len(d.path)-1]
+		j++
 	}
 
-	if n < v.Len() {
+	if j < v.Len() {
 		if v.Kind() == reflect.Array {
 			z := reflect.Zero(v.Type().Elem())
-			for i := n; i < v.Len(); i++ {
+			for i := j; i < v.Len(); i++ {
 				v.Index(i).Set(z)
 			}
+		} else {
+			v.SetLen(j)
 		}
 	}
 
-	if n == 0 && v.Kind() == reflect.Slice {
+	if j == 0 && v.Kind() == reflect.Slice {
 		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
 	}
+	d.leave()
 }
 
 func (d *decodeState) arrayInterface() []interface{} {
-	d.off += 1 // type
-
-	klen := int(Uint8(d.data[d.off:]))
-	d.off += 1 // name length
-
-	// vlen := int(Uint32(d.data[d.off:]))
-	d.off += 4 //  content length
-
-	//var key string
-	d.off += klen
-
-	n := int(Uint32(d.data[d.off:]))
-	d.off += 4 // member number
+	d.enter()
+	d.header()
+	n := d.count()
 
-	v := make([]interface{}, n)
+	d.allocate(n * int(interfaceSize))
+	v := make([]interface{}, 0, n)
 	for i := 0; i < n; i++ {
-		v[i] = d.valueInterface()
+		if d.deleted() {
+			continue
+		}
+		v = append(v, d.valueInterface())
 	}
+	d.leave()
 	return v
 }
 
+// key returns the key of the object member at d.off without moving
+// past it.
 func (d *decodeState) key() []byte {
-	var kstart int
-	switch d.data[d.off] {
-	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64,
-		MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64,
-		MCPACKV2_BOOL, MCPACKV2_FLOAT, MCPACKV2_DOUBLE, MCPACKV2_NULL:
-		kstart = 2 // type + klen
-	case MCPACKV2_SHORT_BINARY, MCPACKV2_SHORT_STRING:
-		kstart = 3 // type + klen + vlen(1)
-	case MCPACKV2_BINARY, MCPACKV2_STRING, MCPACKV2_OBJECT, MCPACKV2_ARRAY:
-		kstart = 6 // type + klen + vlen(4)
-	}
-	klen := int(Uint8(d.data[d.off+1:]))
+	_, klen, _, voff, err := itemHeader(d.data, d.off)
+	if err != nil {
+		d.error(err)
+	}
 	if klen <= 0 {
 		d.error(errEmptyKey)
 	}
-	return d.data[d.off+kstart : d.off+kstart+klen-1]
+	return d.data[voff-klen : voff-1]
 }
 
 type InvalidUnmarshalError struct {
@@ -985,3 +1233,75 @@ func (e *InvalidUnmarshalError) Error() string {
 	}
 	return "mcpack: Unmarshal(nil " + e.Type.String() + ")"
 }
+
+// An UnknownFieldError is returned by a Decoder set to
+// DisallowUnknownFields when an object member matches no field of the
+// struct it is decoded into.
+type UnknownFieldError struct {
+	Field  string // dotted path of the member, such as "user.extra"
+	Offset int    // offset of the member
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "mcpack: unknown field " + strconv.Quote(e.Field) + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// A DepthError is returned when objects and arrays in the data nest
+// deeper than the decoder allows.
+type DepthError struct {
+	Limit  int // maximum depth
+	Offset int // offset of the first container too deep
+}
+
+func (e *DepthError) Error() string {
+	return "mcpack: nesting depth exceeds " + strconv.Itoa(e.Limit) + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// An ElementsError is returned when an object or array in the data has
+// more members than the decoder allows.
+type ElementsError struct {
+	Count  int // number of members of the container
+	Limit  int // maximum number of members
+	Offset int // offset of the member number
+}
+
+func (e *ElementsError) Error() string {
+	return "mcpack: " + strconv.Itoa(e.Count) + " members exceed limit of " + strconv.Itoa(e.Limit) + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// An AllocError is returned when decoding the data would allocate more
+// memory than the decoder allows.
+type AllocError struct {
+	Limit  int // maximum number of bytes
+	Offset int // offset of the item that exceeded it
+}
+
+func (e *AllocError) Error() string {
+	return "mcpack: allocation exceeds " + strconv.Itoa(e.Limit) + " bytes at offset " + strconv.Itoa(e.Offset)
+}
+
+// A SyntaxError is a description of malformed mcpack data.
+type SyntaxError struct {
+	Msg    string // description of error
+	Offset int    // error occurred at this byte offset
+}
+
+func (e *SyntaxError) Error() string {
+	return "mcpack: " + e.Msg + " at offset " + strconv.Itoa(e.Offset)
+}
+
+// An UnmarshalTypeError describes an mcpack item that was not
+// appropriate for a value of a specific Go type.
+type UnmarshalTypeError struct {
+	Value  string       // description of the item - "string", "int32", "object"
+	Type   reflect.Type // type of Go value it could not be assigned to
+	Offset int          // offset of the item
+	Field  string       // path of the item, such as "user.tags[3]"
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Field != "" {
+		return "mcpack: cannot unmarshal " + e.Value + " into Go struct field " + e.Field + " of type " + e.Type.String()
+	}
+	return "mcpack: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
@@ -24,9 +24,9 @@ type ping struct {
 }
 
 type UV struct {
-	F1 *UU    `json:"F1"`
-	F2 int32  `json:"F2"`
-	F3 Number `json:"F3"`
+	F1 *UU     `json:"F1"`
+	F2 int32   `json:"F2"`
+	F3 Integer `json:"F3"`
 }
 
 type UU struct {
@@ -96,3 +96,349 @@ func TestUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestUnmarshalTruncated(t *testing.T) {
+	for _, tt := range marshalTests {
+		if tt.out == nil {
+			continue
+		}
+		for i := 0; i < len(tt.out); i++ {
+			var v interface{}
+			err := Unmarshal(tt.out[:i], &v)
+			if _, ok := err.(*SyntaxError); !ok {
+				t.Errorf("Unmarshal(%#v[:%d]) = %v, expect *SyntaxError", tt.in, i, err)
+			}
+		}
+	}
+}
+
+func TestUnmarshalTrailing(t *testing.T) {
+	b, _ := Marshal(int32(1))
+	var v int32
+	err := Unmarshal(append(b, 0), &v)
+	if se, ok := err.(*SyntaxError); !ok || se.Offset != len(b) {
+		t.Errorf("expected *SyntaxError at offset %d, got %v", len(b), err)
+	}
+}
+
+func TestUnmarshalCorrupt(t *testing.T) {
+	in := []byte{MCPACKV2_OBJECT, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f,
+		MCPACKV2_STRING, 4, 4, 0, 0, 0, 'f', 'o', 'o', 0, 'b', 'a', 'r', 0}
+	err := Unmarshal(in, new(obj))
+	if se, ok := err.(*SyntaxError); !ok || se.Offset != 6 {
+		t.Errorf("expected *SyntaxError at offset 6, got %v", err)
+	}
+
+	// every single byte corruption must be reported as an error, not a panic
+	b, _ := Marshal(&V{F1: &U{Alphabet: "a-z"}, F2: 1, F3: Integer(1)})
+	for i := range b {
+		for _, c := range []byte{0x00, 0x7f, 0xff} {
+			in := append([]byte(nil), b...)
+			in[i] = c
+			var v interface{}
+			Unmarshal(in, &v)
+		}
+	}
+}
+
+func TestUnmarshalDeleted(t *testing.T) {
+	in := []byte{MCPACKV2_OBJECT, 0, 49, 0, 0, 0, 3, 0, 0, 0,
+		MCPACKV2_SHORT_STRING | MCPACKV2_DELETED_ITEM, 4, 4, 'f', 'o', 'o', 0, 'o', 'l', 'd', 0,
+		MCPACKV2_SHORT_STRING, 4, 4, 'f', 'o', 'o', 0, 'b', 'a', 'r', 0,
+		MCPACKV2_ARRAY, 4, 13, 0, 0, 0, 'a', 'r', 'r', 0, 2, 0, 0, 0,
+		MCPACKV2_INT32 | MCPACKV2_DELETED_ITEM, 0, 1, 0, 0, 0,
+		MCPACKV2_BOOL, 0, 1}
+
+	var o obj
+	if err := Unmarshal(in, &o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Foo != "bar" {
+		t.Errorf("got %#v, expect bar", o)
+	}
+
+	var m map[string]interface{}
+	if err := Unmarshal(in, &m); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{"foo": "bar", "arr": []interface{}{true}}
+	if !reflect.DeepEqual(m, expect) {
+		t.Errorf("got %#v, expect %#v", m, expect)
+	}
+
+	var a struct {
+		Arr []bool
+	}
+	if err := Unmarshal(in, &a); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Arr) != 1 || !a.Arr[0] {
+		t.Errorf("got %#v, expect [true]", a.Arr)
+	}
+
+	n, err := CountDeleted(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d deleted items, expect 2", n)
+	}
+}
+
+type Profile struct {
+	User struct {
+		Tags []int32 `json:"tags"`
+	} `json:"user"`
+	Name string `json:"name"`
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	in := map[string]interface{}{
+		"user": map[string]interface{}{
+			"tags": []interface{}{int32(0), int32(1), int32(2), "three", int32(4)},
+		},
+		"name": "foo",
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p Profile
+	err = Unmarshal(b, &p)
+	ute, ok := err.(*UnmarshalTypeError)
+	if !ok {
+		t.Fatalf("expected *UnmarshalTypeError, got %v", err)
+	}
+	if ute.Value != "string" || ute.Type != reflect.TypeOf(int32(0)) || ute.Field != "user.tags[3]" {
+		t.Errorf("got %#v", ute)
+	}
+	if b[ute.Offset] != MCPACKV2_SHORT_STRING {
+		t.Errorf("offset %d does not point at the string item", ute.Offset)
+	}
+	// decoding goes on after the mismatched item
+	if p.Name != "foo" || !reflect.DeepEqual(p.User.Tags, []int32{0, 1, 2, 0, 4}) {
+		t.Errorf("got %#v", p)
+	}
+
+	var n int
+	if err := Unmarshal(b, &n); err == nil {
+		t.Error("expected an error unmarshaling an object into int")
+	}
+}
+
+type numericTest struct {
+	in  interface{} // value marshaled to produce the item
+	ptr interface{}
+	out interface{} // nil when an error is expected
+}
+
+var numericTests = []numericTest{
+	{in: int64(7), ptr: new(int32), out: int32(7)},
+	{in: uint32(7), ptr: new(int), out: 7},
+	{in: int32(-1), ptr: new(int64), out: int64(-1)},
+	{in: int64(300), ptr: new(int8), out: nil},
+	{in: int32(-1), ptr: new(uint), out: nil},
+	{in: uint64(1 << 63), ptr: new(int64), out: nil},
+	{in: uint64(1 << 63), ptr: new(uint64), out: uint64(1 << 63)},
+	{in: int32(3), ptr: new(float32), out: float32(3)},
+	{in: 3.0, ptr: new(int16), out: int16(3)},
+	{in: 3.5, ptr: new(int16), out: nil},
+	{in: float32(2.5), ptr: new(float64), out: 2.5},
+	{in: 1e300, ptr: new(float32), out: nil},
+	{in: -1.0, ptr: new(uint8), out: nil},
+	{in: true, ptr: new(int), out: nil},
+	{in: int32(1), ptr: new(bool), out: nil},
+}
+
+func TestUnmarshalNumeric(t *testing.T) {
+	for i, tt := range numericTests {
+		b, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Unmarshal(b, tt.ptr)
+		if tt.out == nil {
+			if _, ok := err.(*UnmarshalTypeError); !ok {
+				t.Errorf("#%d: expected *UnmarshalTypeError, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.ptr).Elem().Interface(); got != tt.out {
+			t.Errorf("#%d: got %#v, expect %#v", i, got, tt.out)
+		}
+	}
+}
+
+func TestDecoderConvertBoolInt(t *testing.T) {
+	b, _ := Marshal(true)
+	i, _ := Marshal(int32(2))
+
+	dec := NewDecoder(bytes.NewReader(append(b, i...)))
+	dec.ConvertBoolInt()
+	var n int
+	if err := dec.Decode(&n); err != nil || n != 1 {
+		t.Errorf("got %d, %v, expect 1", n, err)
+	}
+	var ok bool
+	if err := dec.Decode(&ok); err != nil || !ok {
+		t.Errorf("got %v, %v, expect true", ok, err)
+	}
+}
+
+func TestDecoderStrictFields(t *testing.T) {
+	b, _ := Marshal(map[string]interface{}{"alpha": "a-z", "extra": int32(1)})
+
+	var u U
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&u); err == nil {
+		t.Error("expected an error")
+	} else if e, ok := err.(*UnknownFieldError); !ok || e.Field != "extra" || e.Offset == 0 {
+		t.Errorf("got %v, expect *UnknownFieldError for extra", err)
+	}
+	if u.Alphabet != "a-z" {
+		t.Errorf("got %#v, expect known fields decoded", u)
+	}
+
+	b, _ = Marshal(map[string]string{"ALPHA": "a-z"})
+	u = U{}
+	dec = NewDecoder(bytes.NewReader(append(b, b...)))
+	if err := dec.Decode(&u); err != nil || u.Alphabet != "a-z" {
+		t.Errorf("got %#v, %v, expect a case insensitive match", u, err)
+	}
+	u = U{}
+	dec.CaseSensitive()
+	if err := dec.Decode(&u); err != nil || u.Alphabet != "" {
+		t.Errorf("got %#v, %v, expect no match", u, err)
+	}
+}
+
+func TestDecoderUseNumber(t *testing.T) {
+	in := map[string]interface{}{
+		"i8":  int8(-1),
+		"i32": int32(-2),
+		"u64": uint64(1 << 63),
+		"f":   float32(0.5),
+		"d":   2.5,
+		"s":   "s",
+	}
+	b, _ := Marshal(in)
+	var m map[string]interface{}
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"i8": "-1", "i32": "-2", "u64": "9223372036854775808", "f": "0.5", "d": "2.5"} {
+		n, ok := m[k].(Number)
+		if !ok || n.String() != want {
+			t.Errorf("%s: got %#v, expect Number %s", k, m[k], want)
+		}
+	}
+	if m["s"] != "s" {
+		t.Errorf("s: got %#v", m["s"])
+	}
+	if n := m["i32"].(Number); n.Type() != MCPACKV2_INT32 {
+		t.Errorf("got type %#x, expect INT32", n.Type())
+	}
+	if i, err := m["u64"].(Number).Int64(); err == nil {
+		t.Errorf("got %d, expect overflow", i)
+	}
+	if u, err := m["u64"].(Number).Uint64(); err != nil || u != 1<<63 {
+		t.Errorf("got %d, %v", u, err)
+	}
+
+	// numbers encode back to the items they were decoded from
+	out, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, b) {
+		t.Errorf("got %#v, expect %#v", out, b)
+	}
+
+	var s struct{ N Number }
+	nb, _ := Marshal(map[string]uint16{"N": 7})
+	if err := Unmarshal(nb, &s); err != nil || s.N.String() != "7" || s.N.Type() != MCPACKV2_UINT32 {
+		t.Errorf("got %#v, %v", s, err)
+	}
+}
+
+// nestedArrays returns depth arrays nested in each other.
+func nestedArrays(depth int) []byte {
+	b := []byte{MCPACKV2_NULL, 0, 0}
+	for i := 0; i < depth; i++ {
+		hdr := []byte{MCPACKV2_ARRAY, 0, 0, 0, 0, 0, 1, 0, 0, 0}
+		PutInt32(hdr[2:], int32(4+len(b)))
+		b = append(hdr, b...)
+	}
+	return b
+}
+
+func TestDecoderLimits(t *testing.T) {
+	deep := nestedArrays(DefaultMaxDepth + 1)
+	var v interface{}
+	if err := Unmarshal(deep, &v); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*DepthError); !ok {
+		t.Errorf("got %v, expect *DepthError", err)
+	}
+	if _, err := CountDeleted(deep); err == nil {
+		t.Error("expected CountDeleted to fail")
+	}
+	if err := Unmarshal(nestedArrays(DefaultMaxDepth), &v); err != nil {
+		t.Errorf("got %v at the maximum depth", err)
+	}
+
+	dec := NewDecoder(bytes.NewReader(nestedArrays(4)))
+	dec.SetMaxDepth(3)
+	if err := dec.Decode(&v); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*DepthError); !ok {
+		t.Errorf("got %v, expect *DepthError", err)
+	}
+
+	b, _ := Marshal([]int32{1, 2, 3})
+	var s []int32
+	dec = NewDecoder(bytes.NewReader(append(b, b...)))
+	dec.SetMaxElements(3)
+	if err := dec.Decode(&s); err != nil || len(s) != 3 {
+		t.Errorf("got %v, %v", s, err)
+	}
+	s = nil
+	dec.SetMaxElements(2)
+	if err := dec.Decode(&s); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*ElementsError); !ok {
+		t.Errorf("got %v, expect *ElementsError", err)
+	}
+
+	b, _ = Marshal(map[string]string{"a": string(longVItem[:]), "b": "x"})
+	var m map[string]string
+	dec = NewDecoder(bytes.NewReader(append(b, b...)))
+	dec.SetMaxAlloc(400)
+	if err := dec.Decode(&m); err != nil {
+		t.Errorf("got %v", err)
+	}
+	dec.SetMaxAlloc(300)
+	if err := dec.Decode(&m); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*AllocError); !ok {
+		t.Errorf("got %v, expect *AllocError", err)
+	}
+	dec = NewDecoder(bytes.NewReader(append(b, b...)))
+	if err := dec.Decode(&m); err != nil {
+		t.Errorf("got %v", err)
+	}
+	dec.SetMaxAlloc(100)
+	if err := dec.Decode(&m); err == nil {
+		t.Error("expected an error")
+	} else if e, ok := err.(*AllocError); !ok || e.Offset != len(b) {
+		t.Errorf("got %v, expect *AllocError at offset %d for an item longer than the limit", err, len(b))
+	}
+}
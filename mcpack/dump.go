@@ -0,0 +1,180 @@
+package mcpack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dump writes a human readable description of the mcpack item in data
+// to w. Every item is printed on its own line, indented by depth, with
+// its offset, type, key, key length, content length and value.
+//
+// Dump stops at the first malformed item. The bytes around it are
+// printed as hex with the failing byte marked, and the *SyntaxError
+// describing the problem is returned.
+func Dump(w io.Writer, data []byte) error {
+	d := &dumper{w: bufio.NewWriter(w), data: data}
+	end, err := d.item(data, 0, 0)
+	if err == nil && end != len(data) {
+		err = syntaxError(end, "data after top-level item")
+		d.fail(err, end, 0)
+	}
+	if ferr := d.w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+type dumper struct {
+	w    *bufio.Writer
+	data []byte
+}
+
+// maxDumpValue is the number of bytes of a string or binary value shown
+// before it is elided.
+const maxDumpValue = 64
+
+// item prints the item starting at data[off], which is bounded by the
+// enclosing container, and returns the offset just past it.
+func (d *dumper) item(data []byte, off, depth int) (int, error) {
+	typ, klen, vlen, voff, err := itemHeader(data, off)
+	if err != nil {
+		return 0, d.fail(err, off, depth)
+	}
+	end := voff + vlen
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%08x %s", off, strings.Repeat("  ", depth))
+	if isDeleted(typ) {
+		fmt.Fprintf(&line, "deleted 0x%02x", typ)
+	} else {
+		line.WriteString(typeName(typ))
+	}
+	if klen > 0 {
+		fmt.Fprintf(&line, " key=%s", strconv.Quote(string(data[voff-klen:voff-1])))
+	}
+	fmt.Fprintf(&line, " klen=%d vlen=%d", klen, vlen)
+
+	if isDeleted(typ) {
+		fmt.Fprintf(d.w, "%s\n", line.String())
+		return end, nil
+	}
+	if !validType(typ) {
+		return 0, d.fail(syntaxError(off, fmt.Sprintf("invalid item type 0x%02x", typ)), off, depth)
+	}
+	if klen > 0 && data[voff-1] != 0 {
+		return 0, d.fail(syntaxError(voff-1, "key not terminated by 0x00"), off, depth)
+	}
+
+	it := Item{Type: typ, Value: data[voff:end]}
+	switch typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY:
+		if vlen < 4 {
+			return 0, d.fail(syntaxError(off, "container too short for member count"), off, depth)
+		}
+		n := int(Uint32(data[voff:]))
+		fmt.Fprintf(d.w, "%s members=%d\n", line.String(), n)
+		p := voff + 4
+		for i := 0; i < n; i++ {
+			if p >= end {
+				return 0, d.fail(syntaxError(p, "fewer members than declared"), off, depth+1)
+			}
+			if typ == MCPACKV2_OBJECT && !isDeleted(data[p]) && p+1 < end && data[p+1] == 0 {
+				return 0, d.fail(syntaxError(p, "object member without key"), p, depth+1)
+			}
+			if p, err = d.item(data[:end], p, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		if p != end {
+			return 0, d.fail(syntaxError(p, "container length mismatch"), p, depth+1)
+		}
+		return end, nil
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
+		if vlen == 0 || data[end-1] != 0 {
+			return 0, d.fail(syntaxError(voff, "string not terminated by 0x00"), off, depth)
+		}
+		fmt.Fprintf(&line, " %s", quoteElided(it.Value[:vlen-1]))
+	case MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY:
+		if vlen > maxDumpValue {
+			fmt.Fprintf(&line, " %x...", it.Value[:maxDumpValue])
+		} else {
+			fmt.Fprintf(&line, " %x", it.Value)
+		}
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		fmt.Fprintf(&line, " %d", it.signed())
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		fmt.Fprintf(&line, " %d", it.unsigned())
+	case MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		fmt.Fprintf(&line, " %g", it.Float())
+	case MCPACKV2_BOOL:
+		fmt.Fprintf(&line, " %t", it.Bool())
+	case MCPACKV2_DATE:
+		fmt.Fprintf(&line, " %s", it.Time().UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(d.w, "%s\n", line.String())
+	return end, nil
+}
+
+func quoteElided(s []byte) string {
+	if len(s) > maxDumpValue {
+		return strconv.Quote(string(s[:maxDumpValue])) + "..."
+	}
+	return strconv.Quote(string(s))
+}
+
+// fail prints err and a hex dump of the data from the item starting at
+// off, marking the failing byte. It returns err.
+func (d *dumper) fail(err error, off, depth int) error {
+	serr := err.(*SyntaxError)
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(d.w, "%08x %s!! %s at offset 0x%x\n", off, indent, serr.Msg, serr.Offset)
+
+	// Show at least the line holding the failing byte.
+	start := off &^ 15
+	if serr.Offset < start {
+		start = serr.Offset &^ 15
+	}
+	end := (max(serr.Offset, off) + 64) &^ 15
+	if end > len(d.data) {
+		end = len(d.data)
+	}
+	for p := start; p < end; p += 16 {
+		row := d.data[p:end]
+		if len(row) > 16 {
+			row = row[:16]
+		}
+		var cols, text strings.Builder
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				cols.WriteByte(' ')
+			}
+			if i < len(row) {
+				fmt.Fprintf(&cols, "%02x ", row[i])
+				if c := row[i]; c >= 0x20 && c < 0x7f {
+					text.WriteByte(c)
+				} else {
+					text.WriteByte('.')
+				}
+			} else {
+				cols.WriteString("   ")
+			}
+		}
+		fmt.Fprintf(d.w, "%08x %s%s |%s|\n", p, indent, cols.String(), text.String())
+		if serr.Offset >= p && serr.Offset < p+16 {
+			col := 3 * (serr.Offset - p)
+			if serr.Offset-p >= 8 {
+				col++
+			}
+			fmt.Fprintf(d.w, "         %s%s^^\n", indent, strings.Repeat(" ", col))
+		}
+	}
+	if serr.Offset >= len(d.data) {
+		fmt.Fprintf(d.w, "%08x %s^^ end of data\n", len(d.data), indent)
+	}
+	return err
+}
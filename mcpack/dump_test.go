@@ -0,0 +1,44 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+func TestDump(t *testing.T) {
+	in := []byte{MCPACKV2_OBJECT, 0, 49, 0, 0, 0, 3, 0, 0, 0,
+		MCPACKV2_SHORT_STRING | MCPACKV2_DELETED_ITEM, 4, 4, 'f', 'o', 'o', 0, 'o', 'l', 'd', 0,
+		MCPACKV2_SHORT_STRING, 4, 4, 'f', 'o', 'o', 0, 'b', 'a', 'r', 0,
+		MCPACKV2_ARRAY, 4, 13, 0, 0, 0, 'a', 'r', 'r', 0, 2, 0, 0, 0,
+		MCPACKV2_INT32 | MCPACKV2_DELETED_ITEM, 0, 1, 0, 0, 0,
+		MCPACKV2_BOOL, 0, 1}
+	expect := `00000000 object klen=0 vlen=49 members=3
+0000000a   deleted 0xf0 key="foo" klen=4 vlen=4
+00000015   string key="foo" klen=4 vlen=4 "bar"
+00000020   array key="arr" klen=4 vlen=13 members=2
+0000002e     deleted 0x74 klen=0 vlen=4
+00000034     bool klen=0 vlen=1 true
+`
+	var out bytes.Buffer
+	if err := Dump(&out, in); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expect {
+		t.Errorf("got\n%s\nexpect\n%s", out.String(), expect)
+	}
+
+	bad := append([]byte(nil), in...)
+	bad[0x34] = 0x91
+	out.Reset()
+	err := Dump(&out, bad)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Offset != 0x34 {
+		t.Fatalf("got error %v, expect syntax error at 0x34", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if !strings.Contains(out.String(), "!! invalid item type 0x91") || !strings.HasSuffix(lines[len(lines)-2], "^^") {
+		t.Errorf("failure not marked:\n%s", out.String())
+	}
+}
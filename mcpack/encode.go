@@ -1,28 +1,137 @@
 package mcpack
 
 import (
+	"encoding"
 	"fmt"
+	"math"
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 )
 
+// Marshal returns the mcpack encoding of v.
+//
+// The encoding is deterministic. Struct fields are written in the order
+// they are declared, with the fields promoted from an embedded struct
+// in place of the embedded field, and map members are written sorted by
+// key. Equal values therefore always encode to the same bytes.
+//
+// Map keys are written as is if they are strings, as the result of
+// MarshalText if they implement encoding.TextMarshaler, and in decimal
+// if they are integers. Maps with other key types are unsupported.
+//
+// Values implementing Marshaler are written as the item MarshalMCPACK
+// returns. Otherwise those implementing encoding.TextMarshaler are
+// written as STRING items holding their text, and those implementing
+// encoding.BinaryMarshaler as BINARY items; a type implementing both is
+// written as text. Types registered with RegisterType take precedence
+// over all of these.
 func Marshal(v interface{}) ([]byte, error) {
-	e := &encodeState{}
+	e := newEncodeState()
+	defer freeEncodeState(e)
 	err := e.marshal(v)
 	if err != nil {
 		return nil, err
 	}
-	return e.data[:e.off], nil
+	return append([]byte(nil), e.data[:e.off]...), nil
+}
+
+// AppendMarshal appends the mcpack encoding of v to dst and returns the
+// extended buffer. If dst has room for the encoding, nothing is
+// allocated, so that passing back the buffer of a previous call, as
+// Encoder does, encodes values of similar size without allocating.
+// On error dst is returned unchanged, though the bytes past its length
+// may have been overwritten.
+func AppendMarshal(dst []byte, v interface{}) ([]byte, error) {
+	return appendMarshal(dst, v, encOpts{})
+}
+
+func appendMarshal(dst []byte, v interface{}, opts encOpts) ([]byte, error) {
+	e := newEncodeState()
+	buf := e.data
+	e.data, e.off, e.encOpts = dst[:cap(dst)], len(dst), opts
+	err := e.marshal(v)
+	b := e.data[:e.off]
+	// keep the pooled buffer rather than dst
+	e.data = buf
+	freeEncodeState(e)
+	if err != nil {
+		return dst, err
+	}
+	return b, nil
+}
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into a valid mcpack item. The returned item is spliced into
+// the output under the key of the value being encoded, so its own key
+// is ignored and is usually empty.
+type Marshaler interface {
+	MarshalMCPACK() ([]byte, error)
 }
 
 type encodeState struct {
 	data    []byte
 	off     int
 	scratch [64]byte
+	cycles  cycleGuard
+
+	encOpts
+}
+
+// encOpts holds the options an Encoder sets on its encodeState.
+type encOpts struct {
+	// compactInts enables the INT8, INT16, UINT8 and UINT16 item types
+	compactInts bool
+	// skipUnsupported leaves out values of unsupported types instead
+	// of failing
+	skipUnsupported bool
+	// encoders holds the types registered with an Encoder, nil for
+	// defaultEncoders
+	encoders *encoderCache
+}
+
+var encodeStatePool sync.Pool
+
+// maxPooledBuffer is the capacity above which the buffer of an
+// encodeState is dropped rather than pooled, so that one large value
+// does not pin its buffer for the following small ones.
+const maxPooledBuffer = 64 << 10
+
+// newEncodeState returns an empty encodeState, reusing the buffer of a
+// previously pooled one.
+func newEncodeState() *encodeState {
+	if v := encodeStatePool.Get(); v != nil {
+		e := v.(*encodeState)
+		e.off = 0
+		e.encOpts = encOpts{}
+		e.cycles.reset()
+		return e
+	}
+	return new(encodeState)
+}
+
+// freeEncodeState returns e to the pool unless its buffer is too large
+// to keep.
+func freeEncodeState(e *encodeState) {
+	if cap(e.data) > maxPooledBuffer {
+		return
+	}
+	encodeStatePool.Put(e)
+}
+
+// itemLen returns the length of an item of type typ under key k with
+// vlen bytes of content.
+func itemLen(typ byte, k string, vlen int) int {
+	n := 1 + 1 + vlenSize(typ) + vlen
+	if k != "" {
+		n += len(k) + 1
+	}
+	return n
 }
 
 func max(l, r int) int {
@@ -60,8 +169,41 @@ func (e *encodeState) setKey(k string, l int) {
 	}
 }
 
+// setItem writes the encoded item b under key k, replacing the key b
+// was encoded with.
+func (e *encodeState) setItem(k string, b []byte) {
+	hlen := 2 + vlenSize(b[0])
+	klen := int(b[1])
+	e.resizeIfNeeded(itemLen(b[0], k, len(b)-hlen-klen))
+	//type(1)
+	e.setType(b[0])
+	//klen(1)
+	l := e.setKeyLen(k)
+	//vlen
+	e.off += copy(e.data[e.off:], b[2:hlen])
+	//key(k[:l]) | 0x00
+	e.setKey(k, l)
+	//value
+	e.off += copy(e.data[e.off:], b[hlen+klen:])
+}
+
+// member encodes the container member v with enc and returns the
+// number of items written, which is 0 for a skipped unsupported value.
+func (e *encodeState) member(enc encoderFunc, k string, v reflect.Value) int {
+	off := e.off
+	enc(e, k, v)
+	if e.off == off {
+		return 0
+	}
+	return 1
+}
+
+func (e *encodeState) error(err error) {
+	panic(err)
+}
+
 func (e *encodeState) resizeIfNeeded(n int) {
-	if e.off+n >= cap(e.data) {
+	if e.off+n > cap(e.data) {
 		newcap := max(cap(e.data)*2, e.off+n)
 		newdata := make([]byte, newcap, newcap)
 		copy(newdata, e.data)
@@ -69,7 +211,20 @@ func (e *encodeState) resizeIfNeeded(n int) {
 	}
 }
 
-func (e *encodeState) marshal(v interface{}) (err error) {
+func (e *encodeState) marshal(v interface{}) error {
+	off := e.off
+	if err := e.marshalKey("", v); err != nil {
+		return err
+	}
+	// only container members may be skipped
+	if e.off == off && v != nil {
+		return &UnsupportedTypeError{reflect.TypeOf(v)}
+	}
+	return nil
+}
+
+// marshalKey writes the encoding of v under key k.
+func (e *encodeState) marshalKey(k string, v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			if _, ok := r.(runtime.Error); ok {
@@ -81,57 +236,127 @@ func (e *encodeState) marshal(v interface{}) (err error) {
 			err = r.(error)
 		}
 	}()
-	e.reflectValue("", reflect.ValueOf(v))
+	e.reflectValue(k, reflect.ValueOf(v))
 	return nil
 }
 
 func (e *encodeState) reflectValue(k string, v reflect.Value) {
-	valueEncoder(v)(e, k, v)
+	c := e.encoders
+	if c == nil {
+		c = &defaultEncoders
+	}
+	c.valueEncoder(v)(e, k, v)
 }
 
 type encoderFunc func(e *encodeState, k string, v reflect.Value)
 
-var encoderCache struct {
+// An encoderCache holds the encoders built for the types registered
+// with it, which are consulted before the global registry. Encoders
+// without registered types share defaultEncoders.
+type encoderCache struct {
 	sync.RWMutex
-	m map[reflect.Type]encoderFunc
+	m     map[reflect.Type]encoderFunc
+	gen   int // number of resets, so that stale encoders are not cached
+	types map[reflect.Type]EncodeFunc
 }
 
-func valueEncoder(v reflect.Value) encoderFunc {
+var defaultEncoders encoderCache
+
+func (c *encoderCache) valueEncoder(v reflect.Value) encoderFunc {
 	if !v.IsValid() {
 		return invalidValueEncoder
 	}
-	return typeEncoder(v.Type())
+	return c.typeEncoder(v.Type())
 }
 
-func typeEncoder(t reflect.Type) encoderFunc {
-	encoderCache.RLock()
-	f := encoderCache.m[t]
-	encoderCache.RUnlock()
+func (c *encoderCache) typeEncoder(t reflect.Type) encoderFunc {
+	c.RLock()
+	f := c.m[t]
+	c.RUnlock()
 	if f != nil {
 		return f
 	}
+	return c.newCachedTypeEncoder(t)
+}
 
-	encoderCache.Lock()
-	if encoderCache.m == nil {
-		encoderCache.m = make(map[reflect.Type]encoderFunc)
+// newCachedTypeEncoder builds and caches the encoder for t. It is kept
+// apart from typeEncoder so that the variables captured below do not
+// escape on the cached path.
+func (c *encoderCache) newCachedTypeEncoder(t reflect.Type) (f encoderFunc) {
+	c.Lock()
+	if c.m == nil {
+		c.m = make(map[reflect.Type]encoderFunc)
 	}
+	gen := c.gen
 	var wg sync.WaitGroup
 	wg.Add(1)
-	encoderCache.m[t] = func(e *encodeState, k string, v reflect.Value) {
+	c.m[t] = func(e *encodeState, k string, v reflect.Value) {
 		wg.Wait()
 		f(e, k, v)
 	}
-	encoderCache.Unlock()
+	c.Unlock()
 
-	f = newTypeEncoder(t, true)
+	f = c.newTypeEncoder(t, true)
 	wg.Done()
-	encoderCache.Lock()
-	encoderCache.m[t] = f
-	encoderCache.Unlock()
+	c.Lock()
+	if c.gen == gen {
+		c.m[t] = f
+	}
+	c.Unlock()
 	return f
 }
 
-func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+// reset drops the encoders built so far. Encoders being built keep
+// running but are not cached.
+func (c *encoderCache) reset() {
+	c.Lock()
+	c.m = make(map[reflect.Type]encoderFunc)
+	c.gen++
+	c.Unlock()
+}
+
+// encodeFunc returns the EncodeFunc registered for t with c, or else
+// globally, or nil if there is none.
+func (c *encoderCache) encodeFunc(t reflect.Type) EncodeFunc {
+	if f, ok := c.types[t]; ok {
+		return f
+	}
+	return globalEncodeFunc(t)
+}
+
+var marshalerType = reflect.TypeOf(new(Marshaler)).Elem()
+
+// newTypeEncoder constructs an encoderFunc for a type.
+// The returned encoder only checks CanAddr when allowAddr is true.
+func (c *encoderCache) newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	if f := c.encodeFunc(t); f != nil {
+		return newRegisteredEncoder(f)
+	}
+	if t.Implements(marshalerType) {
+		return marshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr {
+		if reflect.PtrTo(t).Implements(marshalerType) {
+			return newCondAddrEncoder(addrMarshalerEncoder, c.newTypeEncoder(t, false))
+		}
+	}
+	if t.Implements(textMarshalerType) {
+		return textMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr {
+		if reflect.PtrTo(t).Implements(textMarshalerType) {
+			return newCondAddrEncoder(addrTextMarshalerEncoder, c.newTypeEncoder(t, false))
+		}
+	}
+	if t.Implements(binaryMarshalerType) {
+		return binaryMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr {
+		if reflect.PtrTo(t).Implements(binaryMarshalerType) {
+			return newCondAddrEncoder(addrBinaryMarshalerEncoder, c.newTypeEncoder(t, false))
+		}
+	}
+
 	switch t.Kind() {
 	case reflect.Bool:
 		return boolEncoder
@@ -166,28 +391,116 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 	case reflect.Interface:
 		return interfaceEncoder
 	case reflect.Struct:
-		return newStructEncoder(t)
+		return c.newStructEncoder(t)
 	case reflect.Map:
-		return newMapEncoder(t)
+		return c.newMapEncoder(t)
 	case reflect.Slice:
-		return newSliceEncoder(t)
+		return c.newSliceEncoder(t)
 	case reflect.Array:
-		return newArrayEncoder(t)
+		return c.newArrayEncoder(t)
 	case reflect.Ptr:
-		return newPtrEncoder(t)
+		return c.newPtrEncoder(t)
 	default:
 		return unsupportedTypeEncoder
 	}
 }
 
+func marshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	m := v.Interface().(Marshaler)
+	e.marshaler(k, v, m)
+}
+
+func addrMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	va := v.Addr()
+	m := va.Interface().(Marshaler)
+	e.marshaler(k, v, m)
+}
+
+func (e *encodeState) marshaler(k string, v reflect.Value, m Marshaler) {
+	b, err := m.MarshalMCPACK()
+	e.marshaled(k, v, b, err, "")
+}
+
+// marshaled writes the item b returned for v by sourceFunc under key k,
+// or fails with err.
+func (e *encodeState) marshaled(k string, v reflect.Value, b []byte, err error, sourceFunc string) {
+	if err == nil {
+		err = checkValid(b)
+	}
+	if err != nil {
+		e.error(&MarshalerError{Type: v.Type(), Err: err, sourceFunc: sourceFunc})
+	}
+	e.setItem(k, b)
+}
+
+// newRegisteredEncoder returns an encoder calling the EncodeFunc f,
+// which is not called for nil pointers.
+func newRegisteredEncoder(f EncodeFunc) encoderFunc {
+	return func(e *encodeState, k string, v reflect.Value) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			nilEncoder(e, k, v)
+			return
+		}
+		b, err := f(v)
+		e.marshaled(k, v, b, err, "registered EncodeFunc")
+	}
+}
+
+func textMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	e.textMarshaler(k, v, v.Interface().(encoding.TextMarshaler))
+}
+
+func addrTextMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	e.textMarshaler(k, v, v.Addr().Interface().(encoding.TextMarshaler))
+}
+
+func (e *encodeState) textMarshaler(k string, v reflect.Value, m encoding.TextMarshaler) {
+	b, err := m.MarshalText()
+	if err != nil {
+		e.error(&MarshalerError{Type: v.Type(), Err: err, sourceFunc: "MarshalText"})
+	}
+	stringEncoder(e, k, reflect.ValueOf(string(b)))
+}
+
+func binaryMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		nilEncoder(e, k, v)
+		return
+	}
+	e.binaryMarshaler(k, v, v.Interface().(encoding.BinaryMarshaler))
+}
+
+func addrBinaryMarshalerEncoder(e *encodeState, k string, v reflect.Value) {
+	e.binaryMarshaler(k, v, v.Addr().Interface().(encoding.BinaryMarshaler))
+}
+
+func (e *encodeState) binaryMarshaler(k string, v reflect.Value, m encoding.BinaryMarshaler) {
+	b, err := m.MarshalBinary()
+	if err != nil {
+		e.error(&MarshalerError{Type: v.Type(), Err: err, sourceFunc: "MarshalBinary"})
+	}
+	binaryEncoder(e, k, reflect.ValueOf(b))
+}
+
 func unsupportedTypeEncoder(e *encodeState, k string, v reflect.Value) {
+	if !e.skipUnsupported {
+		e.error(&UnsupportedTypeError{v.Type()})
+	}
 }
 
 func invalidValueEncoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func nilEncoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 1)
+	e.resizeIfNeeded(itemLen(MCPACKV2_NULL, k, 1))
 
 	e.setType(MCPACKV2_NULL)
 	e.setKey(k, e.setKeyLen(k))
@@ -197,7 +510,7 @@ func nilEncoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func boolEncoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 1)
+	e.resizeIfNeeded(itemLen(MCPACKV2_BOOL, k, 1))
 
 	e.setType(MCPACKV2_BOOL)
 	e.setKey(k, e.setKeyLen(k))
@@ -212,35 +525,41 @@ func boolEncoder(e *encodeState, k string, v reflect.Value) {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int8Encoder(e *encodeState, k string, v reflect.Value) {
-	// unsupported in libmcpack, uint32 employed
-	/*e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	// unsupported in libmcpack, int32 employed unless compact widths
+	// are enabled
+	if !e.compactInts {
+		int32Encoder(e, k, v)
+		return
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT8, k, 1))
 
 	e.setType(MCPACKV2_INT8)
 	e.setKey(k, e.setKeyLen(k))
 
 	PutInt8(e.data[e.off:], int8(v.Int()))
-	e.off += 1*/
-
-	int32Encoder(e, k, v)
+	e.off += 1
 }
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int16Encoder(e *encodeState, k string, v reflect.Value) {
-	// unsupported in libmcpack, int32 employed
-	/*e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	// unsupported in libmcpack, int32 employed unless compact widths
+	// are enabled
+	if !e.compactInts {
+		int32Encoder(e, k, v)
+		return
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT16, k, 2))
 
 	e.setType(MCPACKV2_INT16)
 	e.setKey(k, e.setKeyLen(k))
 
 	PutInt16(e.data[e.off:], int16(v.Int()))
-	e.off += 2*/
-
-	int32Encoder(e, k, v)
+	e.off += 2
 }
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int32Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT32, k, 4))
 
 	e.setType(MCPACKV2_INT32)
 	e.setKey(k, e.setKeyLen(k))
@@ -251,7 +570,7 @@ func int32Encoder(e *encodeState, k string, v reflect.Value) {
 
 // type(1) | name length(1) | raw name bytes | 0x00 | value bytes
 func int64Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT64, k, 8))
 
 	e.setType(MCPACKV2_INT64)
 	e.setKey(k, e.setKeyLen(k))
@@ -261,32 +580,39 @@ func int64Encoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func uint8Encoder(e *encodeState, k string, v reflect.Value) {
-	// unsupported in libmcpack, uint32 employed
-	/*e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	// unsupported in libmcpack, uint32 employed unless compact widths
+	// are enabled
+	if !e.compactInts {
+		uint32Encoder(e, k, v)
+		return
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_UINT8, k, 1))
 
 	e.setType(MCPACKV2_UINT8)
 	e.setKey(k, e.setKeyLen(k))
 
 	PutUint8(e.data[e.off:], uint8(v.Uint()))
-	e.off += 1*/
-
-	uint32Encoder(e, k, v)
+	e.off += 1
 }
 
 func uint16Encoder(e *encodeState, k string, v reflect.Value) {
-	// unsupported in libmcpack, uint32 employed
-	/*e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	// unsupported in libmcpack, uint32 employed unless compact widths
+	// are enabled
+	if !e.compactInts {
+		uint32Encoder(e, k, v)
+		return
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_UINT16, k, 2))
 
 	e.setType(MCPACKV2_UINT16)
 	e.setKey(k, e.setKeyLen(k))
 
 	PutUint16(e.data[e.off:], uint16(v.Uint()))
-	e.off += 2*/
-
-	uint32Encoder(e, k, v)
+	e.off += 2
 }
+
 func uint32Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.resizeIfNeeded(itemLen(MCPACKV2_UINT32, k, 4))
 
 	e.setType(MCPACKV2_UINT32)
 	e.setKey(k, e.setKeyLen(k))
@@ -295,7 +621,7 @@ func uint32Encoder(e *encodeState, k string, v reflect.Value) {
 	e.off += 4
 }
 func uint64Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.resizeIfNeeded(itemLen(MCPACKV2_UINT64, k, 8))
 
 	e.setType(MCPACKV2_UINT64)
 	e.setKey(k, e.setKeyLen(k))
@@ -305,7 +631,7 @@ func uint64Encoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func float32Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 4)
+	e.resizeIfNeeded(itemLen(MCPACKV2_FLOAT, k, 4))
 
 	e.setType(MCPACKV2_FLOAT)
 	e.setKey(k, e.setKeyLen(k))
@@ -315,7 +641,7 @@ func float32Encoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func float64Encoder(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + len(k) + 1 + 8)
+	e.resizeIfNeeded(itemLen(MCPACKV2_DOUBLE, k, 8))
 
 	e.setType(MCPACKV2_DOUBLE)
 	e.setKey(k, e.setKeyLen(k))
@@ -324,13 +650,96 @@ func float64Encoder(e *encodeState, k string, v reflect.Value) {
 	e.off += 8
 }
 
-func stringEncoder(e *encodeState, k string, v reflect.Value) {
-	//type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value | 0x00
-	//max(short_vitem, long_vitem)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + v.Len() + 1)
+var timeType = reflect.TypeOf(time.Time{})
+
+// DATE items hold the number of seconds elapsed since January 1, 1970
+// UTC, like a C time_t.
+// type(1) | name length(1) | raw name bytes | 0x00 | value bytes(8)
+func dateEncoder(e *encodeState, k string, v reflect.Value) {
+	e.resizeIfNeeded(itemLen(MCPACKV2_DATE, k, 8))
+
+	e.setType(MCPACKV2_DATE)
+	e.setKey(k, e.setKeyLen(k))
+
+	PutInt64(e.data[e.off:], v.Interface().(time.Time).Unix())
+	e.off += 8
+}
+
+// newWireEncoder returns an encoder writing values of type t as items
+// of type wire, or nil if t cannot be written that way.
+func newWireEncoder(t reflect.Type, wire byte) encoderFunc {
+	if t.Kind() == reflect.Ptr {
+		if enc := newWireEncoder(t.Elem(), wire); enc != nil {
+			pe := &ptrEncoder{enc}
+			return pe.encode
+		}
+		return nil
+	}
+	switch wire {
+	case MCPACKV2_DATE:
+		if t == timeType {
+			return dateEncoder
+		}
+	case MCPACKV2_BINARY:
+		if t.Kind() == reflect.String {
+			return stringBinaryEncoder
+		}
+	case MCPACKV2_INT32, MCPACKV2_INT64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if wire == MCPACKV2_INT32 {
+				return forcedInt32Encoder
+			}
+			return forcedInt64Encoder
+		}
+	}
+	return nil
+}
+
+// intValue returns the integer held by v, which may be of any signed
+// or unsigned kind, and whether it fits in an int64.
+func intValue(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		return int64(u), u <= math.MaxInt64
+	}
+	return v.Int(), true
+}
+
+func forcedInt32Encoder(e *encodeState, k string, v reflect.Value) {
+	n, ok := intValue(v)
+	if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+		e.error(&UnsupportedValueError{v, fmt.Sprintf("%v overflows int32", v.Interface())})
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT32, k, 4))
 
+	e.setType(MCPACKV2_INT32)
+	e.setKey(k, e.setKeyLen(k))
+
+	PutInt32(e.data[e.off:], int32(n))
+	e.off += 4
+}
+
+func forcedInt64Encoder(e *encodeState, k string, v reflect.Value) {
+	n, ok := intValue(v)
+	if !ok {
+		e.error(&UnsupportedValueError{v, fmt.Sprintf("%v overflows int64", v.Interface())})
+	}
+	e.resizeIfNeeded(itemLen(MCPACKV2_INT64, k, 8))
+
+	e.setType(MCPACKV2_INT64)
+	e.setKey(k, e.setKeyLen(k))
+
+	PutInt64(e.data[e.off:], n)
+	e.off += 8
+}
+
+func stringEncoder(e *encodeState, k string, v reflect.Value) {
 	vlen := len(v.String()) + 1
 	if vlen < MAX_SHORT_VITEM_LEN {
+		e.resizeIfNeeded(itemLen(MCPACKV2_SHORT_STRING, k, vlen))
 		//type(1) | klen(1) | vlen(1) | key(len(k)) | 0x00 | value | 0x00
 		//type(1)
 		e.setType(MCPACKV2_SHORT_STRING)
@@ -342,6 +751,7 @@ func stringEncoder(e *encodeState, k string, v reflect.Value) {
 		//key(k[0:l]) | 0x00
 		e.setKey(k, l)
 	} else {
+		e.resizeIfNeeded(itemLen(MCPACKV2_STRING, k, vlen))
 		//type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value | 0x00
 		//type(1)
 		e.setType(MCPACKV2_STRING)
@@ -361,12 +771,9 @@ func stringEncoder(e *encodeState, k string, v reflect.Value) {
 }
 
 func binaryEncoder(e *encodeState, k string, v reflect.Value) {
-	//type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value
-	//max(short_vitem, long_vitem)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + v.Len())
-
 	vlen := len(v.Bytes())
 	if vlen <= MAX_SHORT_VITEM_LEN {
+		e.resizeIfNeeded(itemLen(MCPACKV2_SHORT_BINARY, k, vlen))
 		//type(1) | klen(1) | vlen(1) | key(len(k)) | 0x00 | value
 		//type(1)
 		e.setType(MCPACKV2_SHORT_BINARY)
@@ -378,6 +785,7 @@ func binaryEncoder(e *encodeState, k string, v reflect.Value) {
 		//key(k[:l])) | 0x00
 		e.setKey(k, l)
 	} else {
+		e.resizeIfNeeded(itemLen(MCPACKV2_BINARY, k, vlen))
 		//type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | value
 		//type(1)
 		e.setType(MCPACKV2_BINARY)
@@ -393,6 +801,10 @@ func binaryEncoder(e *encodeState, k string, v reflect.Value) {
 	e.off += copy(e.data[e.off:], v.Bytes())
 }
 
+func stringBinaryEncoder(e *encodeState, k string, v reflect.Value) {
+	binaryEncoder(e, k, reflect.ValueOf([]byte(v.String())))
+}
+
 func interfaceEncoder(e *encodeState, k string, v reflect.Value) {
 	if v.IsNil() {
 		nilEncoder(e, k, v)
@@ -408,7 +820,7 @@ type structEncoder struct {
 
 func (se *structEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | field number(4)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+	e.resizeIfNeeded(itemLen(MCPACKV2_OBJECT, k, 4))
 	//type(1)
 	e.setType(MCPACKV2_OBJECT)
 	//klen(1)
@@ -420,29 +832,37 @@ func (se *structEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	//vpos defer
 	vpos := e.off
-	//count(4)
-	PutInt32(e.data[e.off:], int32(len(se.fields)))
+	//count defer
 	e.off += 4
 	//elem
+	n := 0
 	for i, f := range se.fields {
 		fv := fieldByIndex(v, f.index)
 		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) {
 			continue
 		}
-		se.fieldEncs[i](e, f.name, fv)
+		n += e.member(se.fieldEncs[i], f.name, fv)
 	}
+	//count
+	PutInt32(e.data[vpos:], int32(n))
 	//vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
 }
 
-func newStructEncoder(t reflect.Type) encoderFunc {
+func (c *encoderCache) newStructEncoder(t reflect.Type) encoderFunc {
 	fields := cachedTypeFields(t)
 	se := &structEncoder{
 		fields:    fields,
 		fieldEncs: make([]encoderFunc, len(fields)),
 	}
 	for i, f := range fields {
-		se.fieldEncs[i] = typeEncoder(typeByIndex(t, f.index))
+		ft := typeByIndex(t, f.index)
+		se.fieldEncs[i] = c.typeEncoder(ft)
+		if f.wire != MCPACKV2_INVALID {
+			if enc := newWireEncoder(ft, f.wire); enc != nil {
+				se.fieldEncs[i] = enc
+			}
+		}
 	}
 	return se.encode
 }
@@ -452,7 +872,8 @@ type mapEncoder struct {
 }
 
 func (me *mapEncoder) encode(e *encodeState, k string, v reflect.Value) {
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+	e.cycles.enter(v)
+	e.resizeIfNeeded(itemLen(MCPACKV2_OBJECT, k, 4))
 	//type(1)
 	e.setType(MCPACKV2_OBJECT)
 	//klen(1)
@@ -464,21 +885,96 @@ func (me *mapEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	//vpos defer
 	vpos := e.off
-	PutInt32(e.data[e.off:], int32(len(v.MapKeys())))
+	//count defer
 	e.off += 4
 
-	for _, k := range v.MapKeys() {
-		me.elemEnc(e, k.String(), v.MapIndex(k))
+	// sorted, so that equal maps always encode to the same bytes
+	keys := mapKeys(v)
+	n := 0
+	for _, kv := range keys {
+		n += e.member(me.elemEnc, kv.ks, v.MapIndex(kv.v))
 	}
+	//count
+	PutInt32(e.data[vpos:], int32(n))
 	//vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
+	e.cycles.leave(v)
+}
+
+// reflectWithString is a map key and the item key it is written as.
+type reflectWithString struct {
+	v  reflect.Value
+	ks string
+}
+
+// mapKeys returns the keys of the map v sorted by their item keys.
+func mapKeys(v reflect.Value) []reflectWithString {
+	keys := v.MapKeys()
+	sv := make([]reflectWithString, len(keys))
+	for i, k := range keys {
+		sv[i].v = k
+		sv[i].ks = resolveKeyName(k)
+	}
+	sort.Sort(byString(sv))
+	return sv
+}
+
+// resolveKeyName returns the item key of the map key k. String keys are
+// written as is, encoding.TextMarshalers as their text and integers in
+// decimal.
+func resolveKeyName(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return ""
+		}
+		buf, err := tm.MarshalText()
+		if err != nil {
+			panic(&MarshalerError{Type: k.Type(), Err: err, sourceFunc: "MarshalText"})
+		}
+		return string(buf)
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	panic("unexpected map key type")
+}
+
+// byString sorts map keys by their item keys.
+type byString []reflectWithString
+
+func (x byString) Len() int { return len(x) }
+
+func (x byString) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+
+func (x byString) Less(i, j int) bool { return x[i].ks < x[j].ks }
+
+var (
+	textMarshalerType   = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+	binaryMarshalerType = reflect.TypeOf(new(encoding.BinaryMarshaler)).Elem()
+)
+
+// isKeyType reports whether maps with keys of type t can be encoded.
+func isKeyType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return t.Implements(textMarshalerType)
 }
 
-func newMapEncoder(t reflect.Type) encoderFunc {
-	if t.Key().Kind() != reflect.String {
+func (c *encoderCache) newMapEncoder(t reflect.Type) encoderFunc {
+	if !isKeyType(t.Key()) {
 		return unsupportedTypeEncoder
 	}
-	me := &mapEncoder{typeEncoder(t.Elem())}
+	me := &mapEncoder{c.typeEncoder(t.Elem())}
 	return me.encode
 }
 
@@ -487,14 +983,16 @@ type sliceEncoder struct {
 }
 
 func (se *sliceEncoder) encode(e *encodeState, k string, v reflect.Value) {
+	e.cycles.enter(v)
 	se.arrayEnc(e, k, v)
+	e.cycles.leave(v)
 }
 
-func newSliceEncoder(t reflect.Type) encoderFunc {
+func (c *encoderCache) newSliceEncoder(t reflect.Type) encoderFunc {
 	if t.Elem().Kind() == reflect.Uint8 {
 		return binaryEncoder
 	}
-	enc := &sliceEncoder{newArrayEncoder(t)}
+	enc := &sliceEncoder{c.newArrayEncoder(t)}
 	return enc.encode
 }
 
@@ -505,7 +1003,7 @@ type arrayEncoder struct {
 func (ae *arrayEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	// type(1) | klen(1) | vlen(4) | key(len(0)) | 0x00 | field
 	// number(4)
-	e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+	e.resizeIfNeeded(itemLen(MCPACKV2_ARRAY, k, 4))
 	//type(1)
 	e.setType(MCPACKV2_ARRAY)
 	//klen(k[:l])
@@ -517,19 +1015,21 @@ func (ae *arrayEncoder) encode(e *encodeState, k string, v reflect.Value) {
 	e.setKey(k, l)
 	//vpos defer
 	vpos := e.off
-	//count(4)
-	PutInt32(e.data[e.off:], int32(v.Len()))
+	//count defer
 	e.off += 4
 
+	n := 0
 	for i := 0; i < v.Len(); i++ {
-		ae.elemEnc(e, "", v.Index(i))
+		n += e.member(ae.elemEnc, "", v.Index(i))
 	}
+	//count
+	PutInt32(e.data[vpos:], int32(n))
 	//vlen
 	PutInt32(e.data[vlenpos:], int32(e.off-vpos))
 }
 
-func newArrayEncoder(t reflect.Type) encoderFunc {
-	enc := &arrayEncoder{typeEncoder(t.Elem())}
+func (c *encoderCache) newArrayEncoder(t reflect.Type) encoderFunc {
+	enc := &arrayEncoder{c.typeEncoder(t.Elem())}
 	return enc.encode
 }
 
@@ -542,11 +1042,91 @@ func (pe *ptrEncoder) encode(e *encodeState, k string, v reflect.Value) {
 		nilEncoder(e, k, v)
 		return
 	}
+	e.cycles.enter(v)
 	pe.elemEnc(e, k, v.Elem())
+	e.cycles.leave(v)
+}
+
+func (c *encoderCache) newPtrEncoder(t reflect.Type) encoderFunc {
+	enc := &ptrEncoder{c.typeEncoder(t.Elem())}
+	return enc.encode
+}
+
+// startDetectingCyclesAfter is the number of nested pointers, maps and
+// slices the encoder goes through before it starts looking for cycles,
+// which keeps the cost of the check off ordinary values.
+const startDetectingCyclesAfter = 1000
+
+// A cycleGuard detects values that contain themselves through pointers,
+// maps or slices, which would otherwise be encoded until the stack
+// overflows.
+type cycleGuard struct {
+	level int
+	seen  map[cycleKey]struct{}
+}
+
+// cycleKey identifies a pointer, map or slice. Slices sharing their
+// first element but of different lengths are different values.
+type cycleKey struct {
+	ptr uintptr
+	len int
+}
+
+func (g *cycleGuard) key(v reflect.Value) cycleKey {
+	k := cycleKey{ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// enter records that v is being encoded, panicking with an
+// *UnsupportedValueError if v is already being encoded.
+func (g *cycleGuard) enter(v reflect.Value) {
+	if g.level++; g.level <= startDetectingCyclesAfter {
+		return
+	}
+	if g.seen == nil {
+		g.seen = make(map[cycleKey]struct{})
+	}
+	k := g.key(v)
+	if _, ok := g.seen[k]; ok {
+		panic(&UnsupportedValueError{v, fmt.Sprintf("encountered a cycle via %s", v.Type())})
+	}
+	g.seen[k] = struct{}{}
+}
+
+// leave records that v has been encoded.
+func (g *cycleGuard) leave(v reflect.Value) {
+	if g.level--; g.level >= startDetectingCyclesAfter {
+		delete(g.seen, g.key(v))
+	}
+}
+
+// reset forgets the values left entered by an aborted encoding.
+func (g *cycleGuard) reset() {
+	g.level = 0
+	for k := range g.seen {
+		delete(g.seen, k)
+	}
+}
+
+type condAddrEncoder struct {
+	canAddrEnc, elseEnc encoderFunc
+}
+
+func (ce *condAddrEncoder) encode(e *encodeState, k string, v reflect.Value) {
+	if v.CanAddr() {
+		ce.canAddrEnc(e, k, v)
+	} else {
+		ce.elseEnc(e, k, v)
+	}
 }
 
-func newPtrEncoder(t reflect.Type) encoderFunc {
-	enc := &ptrEncoder{typeEncoder(t.Elem())}
+// newCondAddrEncoder returns an encoder that checks whether its value
+// CanAddr and delegates to canAddrEnc if so, else to elseEnc.
+func newCondAddrEncoder(canAddrEnc, elseEnc encoderFunc) encoderFunc {
+	enc := &condAddrEncoder{canAddrEnc: canAddrEnc, elseEnc: elseEnc}
 	return enc.encode
 }
 
@@ -559,6 +1139,7 @@ type field struct {
 	index     []int
 	typ       reflect.Type
 	omitEmpty bool
+	wire      byte // item type forced by the tag options
 }
 
 func fillField(f field) field {
@@ -621,7 +1202,7 @@ func typeFields(t reflect.Type) []field {
 				if sf.PkgPath != "" {
 					continue
 				}
-				tag := sf.Tag.Get("json")
+				tag := fieldTag(sf)
 				if tag == "-" {
 					continue
 				}
@@ -648,6 +1229,7 @@ func typeFields(t reflect.Type) []field {
 						index:     index,
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
+						wire:      opts.wireType(),
 					}))
 					//?? why append twice ?
 					if count[f.typ] > 1 {
@@ -823,3 +1405,37 @@ func (x byIndex) Less(i, j int) bool {
 	}
 	return len(x[i].index) < len(x[j].index)
 }
+
+type MarshalerError struct {
+	Type       reflect.Type
+	Err        error
+	sourceFunc string // name of the method that failed, if not MarshalMCPACK
+}
+
+func (e *MarshalerError) Error() string {
+	srcFunc := e.sourceFunc
+	if srcFunc == "" {
+		srcFunc = "MarshalMCPACK"
+	}
+	return "mcpack: error calling " + srcFunc + " for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+// An UnsupportedTypeError is returned by Marshal when attempting to
+// encode a value of a type mcpack cannot represent, such as a channel,
+// a function or a complex number.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "mcpack: unsupported type: " + e.Type.String()
+}
+
+type UnsupportedValueError struct {
+	Value reflect.Value
+	Str   string
+}
+
+func (e *UnsupportedValueError) Error() string {
+	return "mcpack: unsupported value: " + e.Str
+}
@@ -3,7 +3,11 @@ package mcpack_test
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
 	"testing"
+	"time"
 
 	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
 )
@@ -27,10 +31,10 @@ type U struct {
 type V struct {
 	F1 interface{}
 	F2 int32
-	F3 Number
+	F3 Integer
 }
 
-type Number int
+type Integer int
 
 type W struct {
 	S string
@@ -72,7 +76,7 @@ var marshalTests = []marshalTest{
 			MCPACKV2_SHORT_STRING, 6, 4, 'a', 'l', 'p', 'h', 'a', 0, 'a', '-', 'z', 0},
 	},
 	{
-		in: &V{F1: &U{Alphabet: "a-z"}, F2: 1, F3: Number(1)},
+		in: &V{F1: &U{Alphabet: "a-z"}, F2: 1, F3: Integer(1)},
 		out: []byte{MCPACKV2_OBJECT, 0, 52, 0, 0, 0,
 			3, 0, 0, 0,
 			MCPACKV2_OBJECT, 3, 17, 0, 0, 0, 'F', '1', 0, 1, 0, 0, 0, MCPACKV2_SHORT_STRING, 6, 4, 'a', 'l', 'p', 'h', 'a', 0, 'a', '-', 'z', 0,
@@ -168,3 +172,502 @@ func TestMarshal(t *testing.T) {
 
 	}
 }
+
+type ID uint32
+
+func (id ID) MarshalMCPACK() ([]byte, error) {
+	return []byte{MCPACKV2_SHORT_STRING, 0, 4, 'i', 'd', byte('0' + id), 0}, nil
+}
+
+// MarshalText is shadowed by MarshalMCPACK.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte("text"), nil
+}
+
+type Money struct {
+	Cents int64
+}
+
+func (m *Money) MarshalMCPACK() ([]byte, error) {
+	b := []byte{MCPACKV2_INT64, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	PutInt64(b[2:], m.Cents)
+	return b, nil
+}
+
+type BadMarshaler struct{}
+
+func (BadMarshaler) MarshalMCPACK() ([]byte, error) {
+	return []byte{MCPACKV2_INT32, 0, 1}, nil
+}
+
+type Account struct {
+	ID    ID
+	Money Money
+}
+
+func TestMarshaler(t *testing.T) {
+	b, err := Marshal(&Account{ID: 7, Money: Money{Cents: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []byte{MCPACKV2_OBJECT, 0, 30, 0, 0, 0,
+		2, 0, 0, 0,
+		MCPACKV2_SHORT_STRING, 3, 4, 'I', 'D', 0, 'i', 'd', '7', 0,
+		MCPACKV2_INT64, 6, 'M', 'o', 'n', 'e', 'y', 0, 1, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(b, out) {
+		t.Errorf("got %#v, expect %#v", b, out)
+	}
+
+	// Money is not addressable here, so it is encoded by reflection.
+	b, err = Marshal(Money{Cents: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != MCPACKV2_OBJECT {
+		t.Errorf("expected reflective object encoding, got %#v", b)
+	}
+
+	_, err = Marshal(BadMarshaler{})
+	if _, ok := err.(*MarshalerError); !ok {
+		t.Errorf("expected *MarshalerError, got %v", err)
+	}
+}
+
+type Event struct {
+	At   time.Time  `json:"at,date"`
+	Next *time.Time `json:"next,date"`
+}
+
+func TestMarshalDate(t *testing.T) {
+	at := time.Unix(1400000000, 0)
+	b, err := Marshal(&Event{At: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []byte{MCPACKV2_OBJECT, 0, 25, 0, 0, 0,
+		2, 0, 0, 0,
+		MCPACKV2_DATE, 3, 'a', 't', 0, 0x00, 0x4e, 0x72, 0x53, 0, 0, 0, 0,
+		MCPACKV2_NULL, 5, 'n', 'e', 'x', 't', 0, 0}
+	if !bytes.Equal(b, out) {
+		t.Fatalf("got %#v, expect %#v", b, out)
+	}
+
+	var ev Event
+	if err := Unmarshal(b, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if !ev.At.Equal(at) || ev.At.Location() != time.UTC || ev.Next != nil {
+		t.Errorf("got %v, expect %v", ev, Event{At: at})
+	}
+
+	var m map[string]interface{}
+	if err := Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := m["at"].(time.Time); !ok || got != at.UTC() {
+		t.Errorf("got %#v, expect %v", m["at"], at)
+	}
+}
+
+type Compact struct {
+	A int8
+	B int16
+	C uint8
+	D uint16
+}
+
+func TestEncoderCompactInts(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetCompactInts(true)
+	if err := enc.Encode(&Compact{A: -1, B: -2, C: 3, D: 4}); err != nil {
+		t.Fatal(err)
+	}
+	out := []byte{MCPACKV2_OBJECT, 0, 26, 0, 0, 0,
+		4, 0, 0, 0,
+		MCPACKV2_INT8, 2, 'A', 0, 0xff,
+		MCPACKV2_INT16, 2, 'B', 0, 0xfe, 0xff,
+		MCPACKV2_UINT8, 2, 'C', 0, 3,
+		MCPACKV2_UINT16, 2, 'D', 0, 4, 0}
+	if !bytes.Equal(buf.Bytes(), out) {
+		t.Fatalf("got %#v, expect %#v", buf.Bytes(), out)
+	}
+
+	var c Compact
+	if err := Unmarshal(out, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c != (Compact{A: -1, B: -2, C: 3, D: 4}) {
+		t.Errorf("got %#v", c)
+	}
+
+	var m map[string]interface{}
+	if err := Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["A"] != int8(-1) || m["B"] != int16(-2) || m["C"] != uint8(3) || m["D"] != uint16(4) {
+		t.Errorf("got %#v", m)
+	}
+}
+
+type Tagged struct {
+	Name   string `json:"name" mcpack:"n"`
+	Secret string `json:"secret" mcpack:"-"`
+	Blob   string `mcpack:"blob,binary"`
+	Count  int16  `mcpack:"count,int64"`
+	Small  int64  `mcpack:",int32"`
+}
+
+func TestMarshalMcpackTag(t *testing.T) {
+	in := &Tagged{Name: "a", Secret: "s", Blob: "xy", Count: 2, Small: -1}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []byte{MCPACKV2_OBJECT, 0, 49, 0, 0, 0,
+		4, 0, 0, 0,
+		MCPACKV2_SHORT_STRING, 2, 2, 'n', 0, 'a', 0,
+		MCPACKV2_SHORT_BINARY, 5, 2, 'b', 'l', 'o', 'b', 0, 'x', 'y',
+		MCPACKV2_INT64, 6, 'c', 'o', 'u', 'n', 't', 0, 2, 0, 0, 0, 0, 0, 0, 0,
+		MCPACKV2_INT32, 6, 'S', 'm', 'a', 'l', 'l', 0, 0xff, 0xff, 0xff, 0xff}
+	if !bytes.Equal(b, out) {
+		t.Fatalf("got %#v, expect %#v", b, out)
+	}
+
+	var got Tagged
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	in.Secret = ""
+	if got != *in {
+		t.Errorf("got %#v, expect %#v", got, *in)
+	}
+
+	_, err = Marshal(&struct {
+		N int64 `mcpack:"n,int32"`
+	}{1 << 40})
+	if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("expected *UnsupportedValueError, got %v", err)
+	}
+}
+
+func TestAppendMarshal(t *testing.T) {
+	prefix := []byte("head")
+	for _, tt := range marshalTests {
+		if tt.out == nil {
+			dst := append([]byte(nil), prefix...)
+			if b, err := AppendMarshal(dst, tt.in); err == nil {
+				t.Errorf("AppendMarshal(%#v): an error expected", tt.in)
+			} else if !bytes.Equal(b, prefix) {
+				t.Errorf("AppendMarshal(%#v) = %q on error, expect %q", tt.in, b, prefix)
+			}
+			continue
+		}
+
+		dst := make([]byte, len(prefix), len(prefix)+len(tt.out))
+		copy(dst, prefix)
+		b, err := AppendMarshal(dst, tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &b[0] != &dst[0] {
+			t.Errorf("AppendMarshal(%#v) grew a buffer large enough for the encoding", tt.in)
+		}
+		if !bytes.Equal(b[:len(prefix)], prefix) || !bytes.Equal(b[len(prefix):], tt.out) {
+			t.Errorf("mismatch %#+v, got %#+v, expect: %#+v", tt.in, b, tt.out)
+		}
+	}
+}
+
+func TestMarshalAllocs(t *testing.T) {
+	in := &T{A: true, X: "x", Y: 1}
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := AppendMarshal(buf, in); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("AppendMarshal: got %v allocs, expect 0", allocs)
+	}
+
+	enc := NewEncoder(ioutil.Discard)
+	allocs = testing.AllocsPerRun(100, func() {
+		if err := enc.Encode(in); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Encoder.Encode: got %v allocs, expect 0", allocs)
+	}
+}
+
+type Inner struct {
+	B int32
+	C int32
+}
+
+type Outer struct {
+	A int32
+	Inner
+	D int32
+}
+
+func TestMarshalOrder(t *testing.T) {
+	m := map[string]int32{}
+	for _, k := range []string{"k", "b", "z", "a", "m", "y", "c"} {
+		m[k] = int32(len(m))
+	}
+	want, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	it, _ := Get(want)
+	for iter := it.Members(); iter.Next(); {
+		keys = append(keys, string(iter.Item().Key))
+	}
+	if fmt.Sprint(keys) != "[a b c k m y z]" {
+		t.Errorf("got keys %v, expect them sorted", keys)
+	}
+	for i := 0; i < 10; i++ {
+		if b, _ := Marshal(m); !bytes.Equal(b, want) {
+			t.Fatalf("got %#v, expect %#v", b, want)
+		}
+	}
+
+	b, err := Marshal(&Outer{A: 1, Inner: Inner{B: 2, C: 3}, D: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = keys[:0]
+	it, _ = Get(b)
+	for iter := it.Members(); iter.Next(); {
+		keys = append(keys, string(iter.Item().Key))
+	}
+	if fmt.Sprint(keys) != "[A B C D]" {
+		t.Errorf("got fields %v, expect [A B C D]", keys)
+	}
+}
+
+type Sparse struct {
+	A string `json:",omitempty"`
+	B int32
+	C chan int
+	D func()
+	E map[complex64]string
+	F []complex64
+}
+
+func TestMarshalMemberCount(t *testing.T) {
+	in := &Sparse{B: 1, F: []complex64{1}}
+	if _, err := Marshal(in); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*UnsupportedTypeError); !ok {
+		t.Errorf("got %T, expect *UnsupportedTypeError", err)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetSkipUnsupported(true)
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	out := []byte{MCPACKV2_OBJECT, 0, 24, 0, 0, 0,
+		2, 0, 0, 0,
+		MCPACKV2_INT32, 2, 'B', 0, 1, 0, 0, 0,
+		MCPACKV2_ARRAY, 2, 4, 0, 0, 0, 'F', 0, 0, 0, 0, 0}
+	if !bytes.Equal(buf.Bytes(), out) {
+		t.Fatalf("got %#v, expect %#v", buf.Bytes(), out)
+	}
+	var s Sparse
+	if err := Unmarshal(out, &s); err != nil || s.B != 1 {
+		t.Errorf("got %#v, %v", s, err)
+	}
+
+	// a top-level value cannot be skipped
+	buf.Reset()
+	for _, in := range []interface{}{make(chan int), new(complex64)} {
+		if err := enc.Encode(in); err == nil {
+			t.Errorf("%T: expected an error", in)
+		} else if _, ok := err.(*UnsupportedTypeError); !ok {
+			t.Errorf("%T: got %v, expect *UnsupportedTypeError", in, err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("got %#v written", buf.Bytes())
+	}
+}
+
+type Node struct {
+	Next *Node
+}
+
+func TestMarshalCycle(t *testing.T) {
+	n := &Node{}
+	n.Next = n
+	m := map[string]interface{}{}
+	m["m"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	for _, in := range []interface{}{n, m, s} {
+		if _, err := Marshal(in); err == nil {
+			t.Errorf("%T: expected an error", in)
+		} else if _, ok := err.(*UnsupportedValueError); !ok {
+			t.Errorf("%T: got %v, expect *UnsupportedValueError", in, err)
+		}
+	}
+
+	// a long chain is not a cycle
+	var head *Node
+	for i := 0; i < 2000; i++ {
+		head = &Node{Next: head}
+	}
+	if _, err := Marshal(head); err != nil {
+		t.Error(err)
+	}
+}
+
+type UserID struct {
+	Zone, N int
+}
+
+func (id UserID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d-%d", id.Zone, id.N)), nil
+}
+
+func (id *UserID) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "%d-%d", &id.Zone, &id.N)
+	return err
+}
+
+// TextOnly implements encoding.TextMarshaler but not TextUnmarshaler.
+type TextOnly struct {
+	A, B int
+}
+
+func (x TextOnly) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d-%d", x.A, x.B)), nil
+}
+
+func TestMapKeys(t *testing.T) {
+	tests := []struct {
+		in, out interface{}
+		keys    string
+	}{
+		{map[int64]int32{-1: 1, 10: 2, 2: 3}, &map[int64]int32{}, "[-1 10 2]"},
+		{map[uint8]bool{255: true}, &map[uint8]bool{}, "[255]"},
+		{map[UserID]string{{1, 2}: "a", {0, 9}: "b"}, &map[UserID]string{}, "[0-9 1-2]"},
+	}
+	for _, tt := range tests {
+		b, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		it, _ := Get(b)
+		for iter := it.Members(); iter.Next(); {
+			keys = append(keys, string(iter.Item().Key))
+		}
+		if fmt.Sprint(keys) != tt.keys {
+			t.Errorf("%#v: got keys %v, expect %s", tt.in, keys, tt.keys)
+		}
+		if err := Unmarshal(b, tt.out); err != nil {
+			t.Fatal(err)
+		}
+		if got := reflect.ValueOf(tt.out).Elem().Interface(); !reflect.DeepEqual(got, tt.in) {
+			t.Errorf("got %#v, expect %#v", got, tt.in)
+		}
+	}
+
+	// keys that can be written but not read back are not collapsed
+	b, _ := Marshal(map[TextOnly]int32{{1, 2}: 1, {3, 4}: 2})
+	var tm map[TextOnly]int32
+	if err := Unmarshal(b, &tm); err == nil {
+		t.Error("expected an error")
+	} else if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("got %v, expect *UnmarshalTypeError", err)
+	}
+	if len(tm) != 0 {
+		t.Errorf("got %#v, expect no members", tm)
+	}
+
+	b, _ = Marshal(map[string]int32{"1": 1, "x": 2, "300": 3})
+	m := map[uint8]int32{}
+	err := Unmarshal(b, &m)
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("got %v, expect *UnmarshalTypeError", err)
+	}
+	if !reflect.DeepEqual(m, map[uint8]int32{1: 1}) {
+		t.Errorf("got %#v", m)
+	}
+}
+
+type Checksum [4]byte
+
+func (c Checksum) MarshalBinary() ([]byte, error) {
+	return c[:], nil
+}
+
+func (c *Checksum) UnmarshalBinary(b []byte) error {
+	if len(b) != len(c) {
+		return fmt.Errorf("checksum of %d bytes", len(b))
+	}
+	copy(c[:], b)
+	return nil
+}
+
+type Host struct {
+	Addr net.IP
+	Sum  Checksum
+	User *UserID
+	ID   ID
+}
+
+func TestMarshalTextBinary(t *testing.T) {
+	in := Host{
+		Addr: net.IPv4(10, 0, 0, 1),
+		Sum:  Checksum{1, 2, 3, 4},
+		User: &UserID{3, 7},
+		ID:   5,
+	}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, _ := Get(b)
+	expect := []struct {
+		typ byte
+		val string
+	}{
+		{MCPACKV2_SHORT_STRING, "10.0.0.1\x00"},
+		{MCPACKV2_SHORT_BINARY, "\x01\x02\x03\x04"},
+		{MCPACKV2_SHORT_STRING, "3-7\x00"},
+		{MCPACKV2_SHORT_STRING, "id5\x00"},
+	}
+	i := 0
+	for iter := it.Members(); iter.Next(); i++ {
+		m := iter.Item()
+		if i < len(expect) && (m.Type != expect[i].typ || string(m.Value) != expect[i].val) {
+			t.Errorf("%s: got %#x %q, expect %#x %q", m.Key, m.Type, m.Value, expect[i].typ, expect[i].val)
+		}
+	}
+	if i != len(expect) {
+		t.Fatalf("got %d members, expect %d", i, len(expect))
+	}
+
+	var out Host
+	err = Unmarshal(b, &out)
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("got %v, expect *UnmarshalTypeError for ID", err)
+	}
+	in.ID = 0
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %#v, expect %#v", out, in)
+	}
+
+	b, _ = Marshal(map[string]interface{}{"Sum": []byte{1, 2}})
+	if err := Unmarshal(b, &out); err == nil || err.Error() != "checksum of 2 bytes" {
+		t.Errorf("got %v, expect UnmarshalBinary error", err)
+	}
+}
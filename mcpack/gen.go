@@ -0,0 +1,102 @@
+package mcpack
+
+import (
+	"reflect"
+
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack/internal/genrt"
+)
+
+func init() {
+	genrt.AppendValue = appendValue
+}
+
+// appendValue appends the item Marshal writes for v under the key k to
+// b, for the MarshalMCPACK methods mcpackgen generates.
+func appendValue(b []byte, k string, v interface{}) ([]byte, error) {
+	e := &encodeState{data: b[:cap(b)], off: len(b)}
+	if err := e.marshalKey(k, v); err != nil {
+		return b, err
+	}
+	return e.data[:e.off], nil
+}
+
+// A generatedDecoder is a type with a DecodeMCPACK method generated by
+// mcpackgen. It is decoded with that method in place of UnmarshalMCPACK.
+type generatedDecoder interface {
+	DecodeMCPACK(d genrt.Decoder)
+}
+
+// genDecoder is the genrt.Decoder of a decodeState, through which
+// generated code decodes objects with the same key matching, options
+// and limits as object does for structs.
+type genDecoder decodeState
+
+func (g *genDecoder) BeginObject(v interface{}) bool {
+	d := (*decodeState)(g)
+	switch typ := d.data[d.off]; {
+	case typ == MCPACKV2_OBJECT:
+	case typ == MCPACKV2_NULL:
+		d.null(reflect.ValueOf(v).Elem())
+		return false
+	case !validType(typ):
+		d.skip()
+	default:
+		start := d.off
+		d.next()
+		d.typeError(reflect.ValueOf(v).Elem(), start)
+		return false
+	}
+	d.enter()
+	d.header()
+	d.members = append(d.members, d.count())
+	return true
+}
+
+func (g *genDecoder) More() bool {
+	d := (*decodeState)(g)
+	n := &d.members[len(d.members)-1]
+	for *n > 0 {
+		*n--
+		if !d.deleted() {
+			return true
+		}
+	}
+	d.members = d.members[:len(d.members)-1]
+	d.leave()
+	return false
+}
+
+func (g *genDecoder) Field(names []string) int {
+	d := (*decodeState)(g)
+	key := d.key()
+	f := -1
+	for i, name := range names {
+		if string(key) == name {
+			f = i
+			break
+		}
+	}
+	if f < 0 && !d.caseSensitive {
+		for i, name := range names {
+			if b := []byte(name); foldFunc(b)(b, key) {
+				f = i
+				break
+			}
+		}
+	}
+	d.path = append(d.path, pathElem{key: key, index: -1})
+	if f < 0 && d.disallowUnknownFields {
+		d.saveError(&UnknownFieldError{Field: d.fieldPath(), Offset: d.off})
+	}
+	return f
+}
+
+func (g *genDecoder) Value(v interface{}) {
+	d := (*decodeState)(g)
+	if v == nil {
+		d.value(reflect.Value{})
+	} else {
+		d.value(reflect.ValueOf(v).Elem())
+	}
+	d.path = d.path[:len(d.path)-1]
+}
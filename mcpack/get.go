@@ -0,0 +1,218 @@
+package mcpack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the path leads to no item.
+var ErrNotFound = errors.New("mcpack: item not found")
+
+// An Item is an encoded item located by Get. Its slices refer to the
+// data passed to Get and must not be modified.
+type Item struct {
+	Type  byte
+	Key   []byte // key without its trailing 0x00, nil for array elements
+	Value []byte // content; for objects and arrays the member number and members
+	Raw   []byte // the whole encoded item
+
+	off int // offset of the item in the data it was found in
+}
+
+// Get returns the item reached from the top-level item in data by
+// following path, whose elements are string keys of object members and
+// int indexes of array elements. Deleted items are not counted.
+//
+// Get only reads the item headers along the path. Subtrees are skipped
+// using their content length, without being decoded or validated.
+func Get(data []byte, path ...interface{}) (Item, error) {
+	_, _, vlen, voff, err := itemHeader(data, 0)
+	if err != nil {
+		return Item{}, err
+	}
+	off, end := 0, voff+vlen
+	for _, p := range path {
+		if off, end, err = lookup(data, off, p); err != nil {
+			return Item{}, err
+		}
+	}
+	return itemAt(data[:end], off), nil
+}
+
+// itemAt returns the valid item header at data[off], ending at the end
+// of data.
+func itemAt(data []byte, off int) Item {
+	typ, klen, _, voff, _ := itemHeader(data, off)
+	it := Item{Type: typ, Value: data[voff:], Raw: data[off:], off: off}
+	if klen > 0 {
+		it.Key = data[voff-klen : voff-1]
+	}
+	return it
+}
+
+// lookup returns the extent of the member p of the object or array
+// starting at data[off].
+func lookup(data []byte, off int, p interface{}) (int, int, error) {
+	typ, _, vlen, voff, err := itemHeader(data, off)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch p.(type) {
+	case string:
+		if typ != MCPACKV2_OBJECT {
+			return 0, 0, fmt.Errorf("mcpack: cannot get key %q of %s", p, typeName(typ))
+		}
+	case int:
+		if typ != MCPACKV2_ARRAY {
+			return 0, 0, fmt.Errorf("mcpack: cannot get index %d of %s", p, typeName(typ))
+		}
+	default:
+		return 0, 0, fmt.Errorf("mcpack: invalid path element %#v", p)
+	}
+	end := voff + vlen
+	if vlen < 4 {
+		return 0, 0, syntaxError(off, "container too short for member count")
+	}
+	n := int(Uint32(data[voff:]))
+	data = data[:end]
+	q := voff + 4
+	for i, j := 0, 0; i < n; i++ {
+		mtyp, klen, mvlen, mvoff, err := itemHeader(data, q)
+		if err != nil {
+			return 0, 0, err
+		}
+		mend := mvoff + mvlen
+		if !isDeleted(mtyp) {
+			switch p := p.(type) {
+			case string:
+				if klen > 0 && string(data[mvoff-klen:mvoff-1]) == p {
+					return q, mend, nil
+				}
+			case int:
+				if j == p {
+					return q, mend, nil
+				}
+				j++
+			}
+		}
+		q = mend
+	}
+	return 0, 0, ErrNotFound
+}
+
+// Int returns the value of a signed or unsigned integer item, and 0 for
+// other items. It fails with an *UnmarshalTypeError if the value does
+// not fit in an int64.
+func (it Item) Int() (int64, error) {
+	switch it.Type {
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		return it.signed(), nil
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		u := it.unsigned()
+		if u > math.MaxInt64 {
+			return 0, it.overflowError(u, reflect.TypeOf(int64(0)))
+		}
+		return int64(u), nil
+	}
+	return 0, nil
+}
+
+// Uint returns the value of a signed or unsigned integer item, and 0
+// for other items. It fails with an *UnmarshalTypeError if the value is
+// negative.
+func (it Item) Uint() (uint64, error) {
+	switch it.Type {
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		return it.unsigned(), nil
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		n := it.signed()
+		if n < 0 {
+			return 0, it.overflowError(n, reflect.TypeOf(uint64(0)))
+		}
+		return uint64(n), nil
+	}
+	return 0, nil
+}
+
+// signed returns the value of a signed integer item.
+func (it Item) signed() int64 {
+	switch it.Type {
+	case MCPACKV2_INT8:
+		return int64(Int8(it.Value))
+	case MCPACKV2_INT16:
+		return int64(Int16(it.Value))
+	case MCPACKV2_INT32:
+		return int64(Int32(it.Value))
+	}
+	return Int64(it.Value)
+}
+
+// unsigned returns the value of an unsigned integer item.
+func (it Item) unsigned() uint64 {
+	switch it.Type {
+	case MCPACKV2_UINT8:
+		return uint64(Uint8(it.Value))
+	case MCPACKV2_UINT16:
+		return uint64(Uint16(it.Value))
+	case MCPACKV2_UINT32:
+		return uint64(Uint32(it.Value))
+	}
+	return Uint64(it.Value)
+}
+
+func (it Item) overflowError(val interface{}, t reflect.Type) error {
+	return &UnmarshalTypeError{
+		Value:  fmt.Sprintf("%s %v", typeName(it.Type), val),
+		Type:   t,
+		Offset: it.off,
+	}
+}
+
+// Float returns the value of a FLOAT or DOUBLE item, and 0 for other
+// items.
+func (it Item) Float() float64 {
+	switch it.Type {
+	case MCPACKV2_FLOAT:
+		return float64(Float32(it.Value))
+	case MCPACKV2_DOUBLE:
+		return Float64(it.Value)
+	}
+	return 0
+}
+
+// Bool returns the value of a BOOL item, and false for other items.
+func (it Item) Bool() bool {
+	return it.Type == MCPACKV2_BOOL && len(it.Value) > 0 && it.Value[0] != 0
+}
+
+// Str returns the value of a STRING item, and "" for other items.
+func (it Item) Str() string {
+	switch it.Type {
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
+		if len(it.Value) > 0 {
+			return string(it.Value[:len(it.Value)-1])
+		}
+	}
+	return ""
+}
+
+// Bytes returns the value of a BINARY item, and nil for other items.
+func (it Item) Bytes() []byte {
+	switch it.Type {
+	case MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY:
+		return it.Value
+	}
+	return nil
+}
+
+// Time returns the value of a DATE item, and the zero time.Time for
+// other items.
+func (it Item) Time() time.Time {
+	if it.Type != MCPACKV2_DATE {
+		return time.Time{}
+	}
+	return time.Unix(Int64(it.Value), 0).UTC()
+}
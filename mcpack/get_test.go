@@ -0,0 +1,91 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+func TestGet(t *testing.T) {
+	var p Profile
+	p.User.Tags = []int32{1, 2, 3}
+	p.Name = "gopher"
+	data, err := Marshal(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := Get(data, "user", "tags", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := it.Int(); it.Type != MCPACKV2_INT32 || n != 3 || err != nil || it.Key != nil {
+		t.Errorf("user.tags[2]: got %#v", it)
+	}
+	it, err = Get(data, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(it.Key) != "name" || it.Str() != "gopher" {
+		t.Errorf("name: got %#v", it)
+	}
+	if !bytes.Equal(it.Raw, data[len(data)-len(it.Raw):]) {
+		t.Errorf("name: raw %#v is not the trailing item", it.Raw)
+	}
+
+	for _, path := range [][]interface{}{
+		{"missing"},
+		{"user", "tags", 3},
+	} {
+		if _, err := Get(data, path...); err != ErrNotFound {
+			t.Errorf("%v: got error %v, expect ErrNotFound", path, err)
+		}
+	}
+	if _, err := Get(data, "name", "x"); err == nil {
+		t.Error("expected error getting a key of a string")
+	}
+	if _, err := Get(data[:len(data)-3]); err == nil {
+		t.Error("expected error on truncated data")
+	}
+}
+
+func TestGetDeleted(t *testing.T) {
+	in := []byte{MCPACKV2_OBJECT, 0, 49, 0, 0, 0, 3, 0, 0, 0,
+		MCPACKV2_SHORT_STRING | MCPACKV2_DELETED_ITEM, 4, 4, 'f', 'o', 'o', 0, 'o', 'l', 'd', 0,
+		MCPACKV2_SHORT_STRING, 4, 4, 'f', 'o', 'o', 0, 'b', 'a', 'r', 0,
+		MCPACKV2_ARRAY, 4, 13, 0, 0, 0, 'a', 'r', 'r', 0, 2, 0, 0, 0,
+		MCPACKV2_INT32 | MCPACKV2_DELETED_ITEM, 0, 1, 0, 0, 0,
+		MCPACKV2_BOOL, 0, 1}
+
+	if it, err := Get(in, "foo"); err != nil || it.Str() != "bar" {
+		t.Errorf("foo: got %q, %v", it.Str(), err)
+	}
+	if it, err := Get(in, "arr", 0); err != nil || !it.Bool() {
+		t.Errorf("arr[0]: got %#v, %v", it, err)
+	}
+	if _, err := Get(in, "arr", 1); err != ErrNotFound {
+		t.Errorf("arr[1]: got error %v, expect ErrNotFound", err)
+	}
+}
+
+func TestItemIntOverflow(t *testing.T) {
+	b, _ := Marshal([]interface{}{uint64(1 << 63), int32(-1), uint32(7)})
+	big, _ := Get(b, 0)
+	if n, err := big.Int(); err == nil {
+		t.Errorf("Int: got %d, expect an overflow", n)
+	} else if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("Int: got %v, expect *UnmarshalTypeError", err)
+	}
+	if u, err := big.Uint(); err != nil || u != 1<<63 {
+		t.Errorf("Uint: got %d, %v", u, err)
+	}
+	neg, _ := Get(b, 1)
+	if u, err := neg.Uint(); err == nil {
+		t.Errorf("Uint: got %d, expect an error for a negative value", u)
+	}
+	small, _ := Get(b, 2)
+	if n, err := small.Int(); err != nil || n != 7 {
+		t.Errorf("Int: got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,30 @@
+// Package genrt links package mcpack to the code mcpackgen generates,
+// which reaches it through package mcpackrt.
+package genrt
+
+// A Decoder is the state of the mcpack decoder running a generated
+// DecodeMCPACK method. Decoding through it applies the options, limits
+// and error reporting of that decoder. Errors are recorded or raised by
+// the decoder itself, so the methods return none.
+type Decoder interface {
+	// BeginObject starts decoding the item at hand into the struct
+	// pointed to by v. It reports whether the item is an object, whose
+	// members are then stepped through with More. Other items are
+	// stored as Unmarshal stores them, which for a NULL item zeroes
+	// the struct and for others records a type error.
+	BeginObject(v interface{}) bool
+	// More advances to the next member of the object, skipping
+	// deleted ones. It returns false once there are none left.
+	More() bool
+	// Field returns the index in names of the struct field the key of
+	// the current member matches, as Unmarshal matches keys to
+	// fields, or -1 if there is none. Value is to be called next.
+	Field(names []string) int
+	// Value decodes the current member into the value pointed to by
+	// v, or skips it if v is nil.
+	Value(v interface{})
+}
+
+// AppendValue appends the item Marshal writes for v under the key k to
+// b. It is set by package mcpack.
+var AppendValue func(b []byte, k string, v interface{}) ([]byte, error)
@@ -0,0 +1,77 @@
+package mcpack
+
+import "fmt"
+
+// An Iter steps through the members of an encoded object or array,
+// skipping deleted ones. Like Get, it only reads item headers.
+//
+//	iter := it.Members()
+//	for iter.Next() {
+//		m := iter.Item()
+//		...
+//	}
+//	if err := iter.Err(); err != nil {
+//		...
+//	}
+type Iter struct {
+	data   []byte // content of the container
+	base   int    // offset of data in the buffer the container was found in
+	object bool
+	n, i   int // declared and visited number of members
+	p      int // offset of the next member in data
+	item   Item
+	err    error
+}
+
+// Members returns an Iter over the members of it, which must be an
+// object or an array.
+func (it Item) Members() *Iter {
+	iter := &Iter{data: it.Value, base: it.off + len(it.Raw) - len(it.Value)}
+	switch {
+	case it.Type != MCPACKV2_OBJECT && it.Type != MCPACKV2_ARRAY:
+		iter.err = fmt.Errorf("mcpack: cannot iterate over %s", typeName(it.Type))
+	case len(it.Value) < 4:
+		iter.err = syntaxError(it.off, "container too short for member count")
+	default:
+		iter.object = it.Type == MCPACKV2_OBJECT
+		iter.n = int(Uint32(it.Value))
+		iter.p = 4
+	}
+	return iter
+}
+
+// Next advances to the next member, which is then available through
+// Item. It returns false at the end of the members or on error.
+func (iter *Iter) Next() bool {
+	for iter.err == nil && iter.i < iter.n {
+		iter.i++
+		typ, klen, vlen, voff, err := itemHeader(iter.data, iter.p)
+		if err != nil {
+			iter.err = syntaxError(iter.base+iter.p, err.(*SyntaxError).Msg)
+			return false
+		}
+		p := iter.p
+		iter.p = voff + vlen
+		if isDeleted(typ) {
+			continue
+		}
+		if iter.object && klen == 0 {
+			iter.err = syntaxError(iter.base+p, "object member without key")
+			return false
+		}
+		iter.item = itemAt(iter.data[:iter.p], p)
+		iter.item.off += iter.base
+		return true
+	}
+	return false
+}
+
+// Item returns the current member.
+func (iter *Iter) Item() Item {
+	return iter.item
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (iter *Iter) Err() error {
+	return iter.err
+}
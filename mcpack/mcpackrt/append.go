@@ -0,0 +1,188 @@
+package mcpackrt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack"
+	"gitlab.baidu.com/ksarch/gomcpack/mcpack/internal/genrt"
+)
+
+// The Append functions append a single item with the given key to b
+// and return the extended buffer. They write exactly what mcpack.Marshal
+// writes for the corresponding Go values. An empty key writes an item
+// without a key, as for array elements. The key must not be longer
+// than mcpack.MCPACKV2_KEY_MAX_LEN.
+
+// appendHeader appends the header of an item of type typ with content
+// length vlen under key k.
+//
+// type(1) | klen(1) | vlen(0/1/4) | key(len(k)) | 0x00
+func appendHeader(b []byte, typ byte, k string, vlen int) []byte {
+	if len(k) > mcpack.MCPACKV2_KEY_MAX_LEN {
+		panic(fmt.Errorf("len(key) exceeds %d", mcpack.MCPACKV2_KEY_MAX_LEN))
+	}
+	klen := 0
+	if k != "" {
+		klen = len(k) + 1
+	}
+	b = append(b, typ, byte(klen))
+	switch vlenSize(typ) {
+	case 1:
+		b = append(b, byte(vlen))
+	case 4:
+		b = appendUint32(b, uint32(vlen))
+	}
+	if k != "" {
+		b = append(b, k...)
+		b = append(b, 0)
+	}
+	return b
+}
+
+// vlenSize returns the size of the content length field of items of
+// type typ.
+func vlenSize(typ byte) int {
+	switch {
+	case typ&^mcpack.MCPACKV2_FIXED_ITEM != 0:
+		return 0
+	case typ&mcpack.MCPACKV2_SHORT_ITEM != 0:
+		return 1
+	default:
+		return 4
+	}
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+// AppendNull appends a NULL item.
+func AppendNull(b []byte, k string) []byte {
+	return append(appendHeader(b, mcpack.MCPACKV2_NULL, k, 1), 0)
+}
+
+// AppendBool appends a BOOL item.
+func AppendBool(b []byte, k string, v bool) []byte {
+	b = appendHeader(b, mcpack.MCPACKV2_BOOL, k, 1)
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// AppendInt32 appends an INT32 item.
+func AppendInt32(b []byte, k string, v int32) []byte {
+	return appendUint32(appendHeader(b, mcpack.MCPACKV2_INT32, k, 4), uint32(v))
+}
+
+// AppendInt64 appends an INT64 item.
+func AppendInt64(b []byte, k string, v int64) []byte {
+	return appendUint64(appendHeader(b, mcpack.MCPACKV2_INT64, k, 8), uint64(v))
+}
+
+// AppendUint32 appends a UINT32 item.
+func AppendUint32(b []byte, k string, v uint32) []byte {
+	return appendUint32(appendHeader(b, mcpack.MCPACKV2_UINT32, k, 4), v)
+}
+
+// AppendUint64 appends a UINT64 item.
+func AppendUint64(b []byte, k string, v uint64) []byte {
+	return appendUint64(appendHeader(b, mcpack.MCPACKV2_UINT64, k, 8), v)
+}
+
+// AppendFloat32 appends a FLOAT item.
+func AppendFloat32(b []byte, k string, v float32) []byte {
+	return appendUint32(appendHeader(b, mcpack.MCPACKV2_FLOAT, k, 4), math.Float32bits(v))
+}
+
+// AppendFloat64 appends a DOUBLE item.
+func AppendFloat64(b []byte, k string, v float64) []byte {
+	return appendUint64(appendHeader(b, mcpack.MCPACKV2_DOUBLE, k, 8), math.Float64bits(v))
+}
+
+// AppendString appends a STRING item, or a short one if it fits.
+func AppendString(b []byte, k string, v string) []byte {
+	typ := byte(mcpack.MCPACKV2_STRING)
+	if len(v)+1 < mcpack.MAX_SHORT_VITEM_LEN {
+		typ = mcpack.MCPACKV2_SHORT_STRING
+	}
+	b = appendHeader(b, typ, k, len(v)+1)
+	b = append(b, v...)
+	return append(b, 0)
+}
+
+// AppendBinary appends a BINARY item, or a short one if it fits.
+func AppendBinary(b []byte, k string, v []byte) []byte {
+	typ := byte(mcpack.MCPACKV2_BINARY)
+	if len(v) <= mcpack.MAX_SHORT_VITEM_LEN {
+		typ = mcpack.MCPACKV2_SHORT_BINARY
+	}
+	return append(appendHeader(b, typ, k, len(v)), v...)
+}
+
+// AppendDate appends a DATE item holding the Unix time of t.
+func AppendDate(b []byte, k string, t time.Time) []byte {
+	return appendUint64(appendHeader(b, mcpack.MCPACKV2_DATE, k, 8), uint64(t.Unix()))
+}
+
+// AppendInt appends v as an item of type wire, which is
+// mcpack.MCPACKV2_INT32 or mcpack.MCPACKV2_INT64, as for fields tagged
+// with the int32 or int64 option. It fails if v does not fit.
+func AppendInt(b []byte, k string, v int64, wire byte) ([]byte, error) {
+	if wire == mcpack.MCPACKV2_INT32 {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return b, &mcpack.UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%v overflows int32", v)}
+		}
+		return AppendInt32(b, k, int32(v)), nil
+	}
+	return AppendInt64(b, k, v), nil
+}
+
+// AppendUint is like AppendInt for unsigned values.
+func AppendUint(b []byte, k string, v uint64, wire byte) ([]byte, error) {
+	if v > math.MaxInt64 {
+		name := "int64"
+		if wire == mcpack.MCPACKV2_INT32 {
+			name = "int32"
+		}
+		return b, &mcpack.UnsupportedValueError{Value: reflect.ValueOf(v), Str: fmt.Sprintf("%v overflows %s", v, name)}
+	}
+	return AppendInt(b, k, int64(v), wire)
+}
+
+// AppendValue appends the encoding of v as mcpack.Marshal writes it.
+func AppendValue(b []byte, k string, v interface{}) ([]byte, error) {
+	return genrt.AppendValue(b, k, v)
+}
+
+// BeginObject appends the header of an OBJECT item and returns its
+// offset in b. The members are to be appended next, after which
+// EndContainer completes the item.
+func BeginObject(b []byte, k string) ([]byte, int) {
+	off := len(b)
+	return appendUint32(appendHeader(b, mcpack.MCPACKV2_OBJECT, k, 0), 0), off
+}
+
+// BeginArray appends the header of an ARRAY item, as BeginObject does.
+func BeginArray(b []byte, k string) ([]byte, int) {
+	off := len(b)
+	return appendUint32(appendHeader(b, mcpack.MCPACKV2_ARRAY, k, 0), 0), off
+}
+
+// EndContainer completes the object or array at offset off in b, as
+// returned by BeginObject or BeginArray, which has n members.
+func EndContainer(b []byte, off, n int) []byte {
+	// type(1) | klen(1) | vlen(4) | key(klen) | count(4) | members
+	vpos := off + 6 + int(b[off+1])
+	mcpack.PutInt32(b[vpos:], int32(n))
+	mcpack.PutInt32(b[off+2:], int32(len(b)-vpos))
+	return b
+}
@@ -0,0 +1,12 @@
+// Package mcpackrt holds the functions called by the methods mcpackgen
+// generates. It is not meant to be used by other code, and its API
+// changes together with mcpackgen.
+package mcpackrt
+
+import "gitlab.baidu.com/ksarch/gomcpack/mcpack/internal/genrt"
+
+// A Decoder is the state of the mcpack decoder given to generated
+// DecodeMCPACK methods. mcpack.Unmarshal and mcpack.Decoder call these
+// methods in place of UnmarshalMCPACK, so that generated code decodes
+// with their options and limits.
+type Decoder = genrt.Decoder
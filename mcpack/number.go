@@ -2,10 +2,13 @@ package mcpack
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math"
+	"reflect"
+	"strconv"
 )
 
-/*func Int8(b []byte) int8 {
+func Int8(b []byte) int8 {
 	return int8(b[0])
 }
 
@@ -20,7 +23,7 @@ func Int16(b []byte) int16 {
 func PutInt16(b []byte, v int16) {
 	b[0] = byte(uint16(v))
 	b[1] = byte(uint16(v) >> 8)
-}*/
+}
 
 func Int32(b []byte) int32 {
 	return int32(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24)
@@ -57,14 +60,14 @@ func PutUint8(b []byte, v uint8) {
 	b[0] = byte(v)
 }
 
-/*func Uint16(b []byte) uint16 {
+func Uint16(b []byte) uint16 {
 	return uint16(b[0]) | uint16(b[1])<<8
 }
 
 func PutUint16(b []byte, v uint16) {
 	b[0] = byte(v)
 	b[1] = byte(v >> 8)
-}*/
+}
 
 func Uint32(b []byte) uint32 {
 	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
@@ -112,3 +115,176 @@ func PutFloat64(b []byte, v float64) {
 	bits := math.Float64bits(v)
 	binary.LittleEndian.PutUint64(b, bits)
 }
+
+// A Number is a numeric item decoded into an interface{} value by a
+// Decoder with UseNumber set. It keeps the item type along with the
+// value, so that it encodes back to the same item. A Number field of a
+// struct accepts any numeric item.
+type Number struct {
+	typ byte
+	val [8]byte // content of the item
+}
+
+// Type returns the item type of n, such as MCPACKV2_INT32, or
+// MCPACKV2_INVALID for the zero Number.
+func (n Number) Type() byte {
+	return n.typ
+}
+
+func (n Number) item() Item {
+	return Item{Type: n.typ, Value: n.val[:n.typ&^MCPACKV2_FIXED_ITEM]}
+}
+
+// Int64 returns n as an int64. It fails if n is not an integer or does
+// not fit.
+func (n Number) Int64() (int64, error) {
+	i, val, ok := decodeInt(n.item(), math.MinInt64, math.MaxInt64)
+	if !ok {
+		return 0, n.item().typeError(reflect.TypeOf(i), val)
+	}
+	return i, nil
+}
+
+// Uint64 returns n as a uint64. It fails if n is not a non-negative
+// integer or does not fit.
+func (n Number) Uint64() (uint64, error) {
+	u, val, ok := decodeUint(n.item(), math.MaxUint64)
+	if !ok {
+		return 0, n.item().typeError(reflect.TypeOf(u), val)
+	}
+	return u, nil
+}
+
+// Float64 returns n as a float64.
+func (n Number) Float64() float64 {
+	f, _, _ := decodeFloat(n.item(), 64)
+	return f
+}
+
+// String returns the decimal representation of n.
+func (n Number) String() string {
+	it := n.item()
+	switch n.typ {
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		return strconv.FormatInt(it.signed(), 10)
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		return strconv.FormatUint(it.unsigned(), 10)
+	case MCPACKV2_FLOAT:
+		return strconv.FormatFloat(it.Float(), 'g', -1, 32)
+	case MCPACKV2_DOUBLE:
+		return strconv.FormatFloat(it.Float(), 'g', -1, 64)
+	}
+	return "0"
+}
+
+// MarshalMCPACK returns the item n was decoded from. The zero Number
+// is written as NULL.
+func (n Number) MarshalMCPACK() ([]byte, error) {
+	if !isNumber(n.typ) {
+		return []byte{MCPACKV2_NULL, 0, 0}, nil
+	}
+	return append([]byte{n.typ, 0}, n.item().Value...), nil
+}
+
+// UnmarshalMCPACK sets *n to the numeric item in data. A NULL item
+// sets the zero Number.
+func (n *Number) UnmarshalMCPACK(data []byte) error {
+	it, err := Get(data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case it.Type == MCPACKV2_NULL:
+		*n = Number{}
+	case isNumber(it.Type):
+		*n = Number{typ: it.Type}
+		copy(n.val[:], it.Value)
+	default:
+		return it.typeError(reflect.TypeOf(*n), nil)
+	}
+	return nil
+}
+
+func isNumber(typ byte) bool {
+	switch typ {
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64,
+		MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64,
+		MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		return true
+	}
+	return false
+}
+
+// typeError returns an *UnmarshalTypeError for it and the type t. If
+// val is not nil, the error reports that val overflows t.
+func (it Item) typeError(t reflect.Type, val interface{}) error {
+	desc := typeName(it.Type)
+	if val != nil {
+		desc = fmt.Sprintf("%s %v", desc, val)
+	}
+	return &UnmarshalTypeError{Value: desc, Type: t, Offset: it.off, Field: string(it.Key)}
+}
+
+// decodeInt returns the value of it for an integer in [min, max]. If
+// it does not fit, ok is false and val describes the offending value;
+// if it is not numeric, val is nil.
+func decodeInt(it Item, min, max int64) (n int64, val interface{}, ok bool) {
+	switch it.Type {
+	case MCPACKV2_NULL:
+		return 0, nil, true
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		n := it.signed()
+		return n, n, n >= min && n <= max
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		u := it.unsigned()
+		return int64(u), u, u <= uint64(max)
+	case MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		f := it.Float()
+		// -2^63 is exact in float64, 2^63 is the first value out of range
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= -math.MinInt64 {
+			return 0, f, false
+		}
+		return int64(f), f, int64(f) >= min && int64(f) <= max
+	}
+	return 0, nil, false
+}
+
+// decodeUint is like decodeInt for an unsigned integer up to max.
+func decodeUint(it Item, max uint64) (n uint64, val interface{}, ok bool) {
+	switch it.Type {
+	case MCPACKV2_NULL:
+		return 0, nil, true
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		n := it.signed()
+		return uint64(n), n, n >= 0 && uint64(n) <= max
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		u := it.unsigned()
+		return u, u, u <= max
+	case MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		f := it.Float()
+		if f != math.Trunc(f) || f < 0 || f >= 2*-math.MinInt64 {
+			return 0, f, false
+		}
+		return uint64(f), f, uint64(f) <= max
+	}
+	return 0, nil, false
+}
+
+// decodeFloat returns the value of it for a float of the given bits.
+func decodeFloat(it Item, bits int) (f float64, val interface{}, ok bool) {
+	switch it.Type {
+	case MCPACKV2_NULL:
+		return 0, nil, true
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		return float64(it.signed()), nil, true
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		return float64(it.unsigned()), nil, true
+	case MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		f := it.Float()
+		if bits == 32 && !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+			return 0, f, false
+		}
+		return f, f, true
+	}
+	return 0, nil, false
+}
@@ -0,0 +1,34 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+func TestInt8Int16Uint16(t *testing.T) {
+	var b [2]byte
+	for _, v := range []int8{0, 1, -1, 127, -128} {
+		PutInt8(b[:], v)
+		if got := Int8(b[:]); got != v {
+			t.Errorf("Int8: got %d, expect %d", got, v)
+		}
+	}
+	for _, v := range []int16{0, 1, -1, 32767, -32768, -18} {
+		PutInt16(b[:], v)
+		if got := Int16(b[:]); got != v {
+			t.Errorf("Int16: got %d, expect %d", got, v)
+		}
+	}
+	PutInt16(b[:], -18)
+	if !bytes.Equal(b[:], []byte{0xee, 0xff}) {
+		t.Errorf("PutInt16: got %#v, expect little endian", b)
+	}
+	for _, v := range []uint16{0, 1, 0x1234, 65535} {
+		PutUint16(b[:], v)
+		if got := Uint16(b[:]); got != v {
+			t.Errorf("Uint16: got %d, expect %d", got, v)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package mcpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Patch edits encoded mcpack documents without decoding them. Items
+// are addressed by paths as in Get. Each edit marshals the new value
+// as by Marshal, splices it into the buffer and fixes up the content
+// lengths of all enclosing objects and arrays as well as the member
+// count of the immediate one. Everything else, including fields unknown
+// to the caller, is kept byte for byte.
+//
+// The edit methods return the patched document, which may share
+// storage with data. data should not be used after a successful edit.
+type Patch struct {
+	// Tombstone causes Delete to mark items as deleted with
+	// MCPACKV2_DELETED_ITEM and zero their key and content instead of
+	// removing their bytes, so that the document is modified in place
+	// and keeps its size.
+	Tombstone bool
+}
+
+var errEmptyPath = errors.New("mcpack: patch path is empty")
+
+// Set replaces the item at path with the encoding of v, or inserts it
+// if there is no such item.
+func (p Patch) Set(data []byte, v interface{}, path ...interface{}) ([]byte, error) {
+	out, err := p.Replace(data, v, path...)
+	if err == ErrNotFound {
+		return p.Insert(data, v, path...)
+	}
+	return out, err
+}
+
+// Replace replaces the item at path with the encoding of v. It returns
+// ErrNotFound if there is no such item.
+func (p Patch) Replace(data []byte, v interface{}, path ...interface{}) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, errEmptyPath
+	}
+	parents, off, end, err := walk(data, path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := memberItem(v, path[len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == end-off {
+		copy(data[off:], b)
+		return data, nil
+	}
+	return splice(data, parents, off, end, b, 0), nil
+}
+
+// Insert adds the encoding of v to the container at path[:len(path)-1].
+// If the last path element is a key, v is appended as a new member and
+// the key must not already be present. If it is an index, v is inserted
+// before the element at that index, or appended if the index equals
+// the number of elements.
+func (p Patch) Insert(data []byte, v interface{}, path ...interface{}) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, errEmptyPath
+	}
+	last := path[len(path)-1]
+	parents, poff, _, err := walk(data, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	at, err := insertPos(data, poff, last)
+	if err != nil {
+		return nil, err
+	}
+	b, err := memberItem(v, last)
+	if err != nil {
+		return nil, err
+	}
+	return splice(data, append(parents, poff), at, at, b, 1), nil
+}
+
+// Delete removes the item at path. It returns ErrNotFound if there is
+// no such item.
+func (p Patch) Delete(data []byte, path ...interface{}) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, errEmptyPath
+	}
+	parents, off, end, err := walk(data, path)
+	if err != nil {
+		return nil, err
+	}
+	if p.Tombstone {
+		// clear the key and content, so that a deleted secret is not
+		// passed on
+		_, klen, _, voff, _ := itemHeader(data, off)
+		for i := voff - klen; i < end; i++ {
+			data[i] = 0
+		}
+		data[off] |= MCPACKV2_DELETED_ITEM
+		return data, nil
+	}
+	return splice(data, parents, off, end, nil, -1), nil
+}
+
+// walk follows path from the top-level item in data as Get does. It
+// returns the offsets of the containers passed through, outermost
+// first, and the extent of the item reached.
+func walk(data []byte, path []interface{}) (parents []int, off, end int, err error) {
+	_, _, vlen, voff, err := itemHeader(data, 0)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	end = voff + vlen
+	for _, p := range path {
+		parents = append(parents, off)
+		if off, end, err = lookup(data, off, p); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return parents, off, end, nil
+}
+
+// insertPos returns the offset at which member p is to be inserted into
+// the container at data[off].
+func insertPos(data []byte, off int, p interface{}) (int, error) {
+	at, _, err := lookup(data, off, p)
+	switch {
+	case err == nil:
+		if k, ok := p.(string); ok {
+			return 0, fmt.Errorf("mcpack: key %q already exists", k)
+		}
+		return at, nil
+	case err != ErrNotFound:
+		return 0, err
+	}
+	if i, ok := p.(int); ok && i != 0 {
+		if _, _, err := lookup(data, off, i-1); i < 0 || err != nil {
+			return 0, fmt.Errorf("mcpack: index %d out of range", i)
+		}
+	}
+	_, _, vlen, voff, _ := itemHeader(data, off)
+	return voff + vlen, nil
+}
+
+// memberItem returns the encoding of v as the member p of a container.
+// A nil v is written as NULL.
+func memberItem(v interface{}, p interface{}) ([]byte, error) {
+	b, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		b = []byte{MCPACKV2_NULL, 0, 0}
+	}
+	k, isKey := p.(string)
+	if isKey && (k == "" || len(k) > MCPACKV2_KEY_MAX_LEN) {
+		return nil, fmt.Errorf("mcpack: invalid key length %d", len(k))
+	}
+	e := &encodeState{}
+	e.setItem(k, b)
+	return e.data[:e.off], nil
+}
+
+// splice replaces data[off:end] with b and adjusts the content lengths
+// of the containers at the offsets in parents by the change in size.
+// The member count of the last of them is adjusted by dn.
+func splice(data []byte, parents []int, off, end int, b []byte, dn int) []byte {
+	out := make([]byte, 0, len(data)-(end-off)+len(b))
+	out = append(out, data[:off]...)
+	out = append(out, b...)
+	out = append(out, data[end:]...)
+
+	d := len(b) - (end - off)
+	for _, c := range parents {
+		// type(1) | klen(1) | vlen(4) | key(klen) | count(4)
+		PutUint32(out[c+2:], uint32(int(Uint32(out[c+2:]))+d))
+	}
+	if c := parents[len(parents)-1]; dn != 0 {
+		n := c + 6 + int(out[c+1])
+		PutUint32(out[n:], uint32(int(Uint32(out[n:]))+dn))
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+type patchDoc struct {
+	LogID  int64             `json:"log_id"`
+	Secret string            `json:"secret"`
+	Tags   []string          `json:"tags"`
+	Extra  map[string]string `json:"extra"`
+}
+
+func TestPatch(t *testing.T) {
+	in, err := Marshal(&patchDoc{
+		LogID:  1,
+		Secret: "hunter2",
+		Tags:   []string{"a", "c"},
+		Extra:  map[string]string{"k": "v"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p Patch
+	steps := []func(b []byte) ([]byte, error){
+		func(b []byte) ([]byte, error) { return p.Replace(b, int64(2), "log_id") },
+		func(b []byte) ([]byte, error) { return p.Delete(b, "secret") },
+		func(b []byte) ([]byte, error) { return p.Insert(b, "b", "tags", 1) },
+		func(b []byte) ([]byte, error) { return p.Insert(b, "d", "tags", 3) },
+		func(b []byte) ([]byte, error) { return p.Set(b, "longer value", "extra", "k") },
+		func(b []byte) ([]byte, error) { return p.Set(b, "new", "extra", "n") },
+	}
+	b := in
+	for i, step := range steps {
+		if b, err = step(b); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !Valid(b) {
+			t.Fatalf("step %d: invalid output %#v", i, b)
+		}
+	}
+
+	var got map[string]interface{}
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"log_id": int64(2),
+		"tags":   []interface{}{"a", "b", "c", "d"},
+		"extra":  map[string]interface{}{"k": "longer value", "n": "new"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %#v, expect %#v", got, expect)
+	}
+
+	if _, err := p.Replace(b, 1, "missing"); err != ErrNotFound {
+		t.Errorf("Replace missing: got error %v, expect ErrNotFound", err)
+	}
+	if _, err := p.Insert(b, 1, "log_id"); err == nil {
+		t.Error("expected error inserting an existing key")
+	}
+	if _, err := p.Insert(b, "x", "tags", 5); err == nil {
+		t.Error("expected error inserting past the end of an array")
+	}
+}
+
+func TestPatchNil(t *testing.T) {
+	b, _ := Marshal(&patchDoc{LogID: 1, Secret: "hunter2", Tags: []string{"a"}})
+	var p Patch
+	var err error
+	steps := []func(b []byte) ([]byte, error){
+		func(b []byte) ([]byte, error) { return p.Set(b, nil, "secret") },
+		func(b []byte) ([]byte, error) { return p.Replace(b, nil, "log_id") },
+		func(b []byte) ([]byte, error) { return p.Insert(b, nil, "tags", 0) },
+		func(b []byte) ([]byte, error) { return p.Set(b, nil, "new") },
+	}
+	for i, step := range steps {
+		if b, err = step(b); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	var got map[string]interface{}
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"log_id": nil,
+		"secret": nil,
+		"tags":   []interface{}{nil, "a"},
+		"extra":  map[string]interface{}{},
+		"new":    nil,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %#v, expect %#v", got, expect)
+	}
+}
+
+func TestPatchTombstone(t *testing.T) {
+	in, _ := Marshal(&patchDoc{LogID: 1, Secret: "hunter2"})
+	n := len(in)
+	out, err := Patch{Tombstone: true}.Delete(in, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != n || &out[0] != &in[0] {
+		t.Error("expected the document to be modified in place")
+	}
+	if bytes.Contains(out, []byte("hunter2")) || bytes.Contains(out, []byte("secret")) {
+		t.Errorf("deleted item still readable in %q", out)
+	}
+	if d, err := CountDeleted(out); err != nil || d != 1 {
+		t.Errorf("got %d deleted items, %v", d, err)
+	}
+	var doc patchDoc
+	if err := Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Secret != "" || doc.LogID != 1 {
+		t.Errorf("got %#v", doc)
+	}
+}
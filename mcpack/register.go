@@ -0,0 +1,89 @@
+package mcpack
+
+import (
+	"reflect"
+	"sync"
+)
+
+// An EncodeFunc returns the mcpack encoding of v, a value of the type it
+// is registered for, in the form MarshalMCPACK returns it.
+type EncodeFunc func(v reflect.Value) ([]byte, error)
+
+// A DecodeFunc stores an item into v, a settable value of the type it
+// is registered for. The item is passed as to UnmarshalMCPACK, with its
+// key removed, and must be copied if it is to be retained.
+type DecodeFunc func(data []byte, v reflect.Value) error
+
+// registeredTypes holds the functions registered with RegisterType. The
+// maps are replaced rather than modified, so that a decodeState can
+// keep the one it started with.
+var registeredTypes struct {
+	sync.RWMutex
+	enc map[reflect.Type]EncodeFunc
+	dec map[reflect.Type]DecodeFunc
+}
+
+// RegisterType registers enc and dec to encode and decode the values of
+// type t, which lets types from other packages, such as time.Duration,
+// be given an encoding without adding methods to them. Registered
+// functions take precedence over Marshaler, Unmarshaler and the other
+// rules of Marshal and Unmarshal, though not over the wire options of a
+// field's tag. Nil pointers of a registered type are encoded as NULL
+// without calling enc.
+//
+// A nil enc or dec removes the registration for that direction. Types
+// are best registered from an init function: encoders already built
+// for an Encoder with its own registered types are not updated.
+// Registered functions are not used by the MarshalMCPACK methods
+// mcpackgen generates, though its decoding methods use them.
+func RegisterType(t reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	r := &registeredTypes
+	r.Lock()
+	encs := make(map[reflect.Type]EncodeFunc, len(r.enc)+1)
+	for k, f := range r.enc {
+		encs[k] = f
+	}
+	decs := make(map[reflect.Type]DecodeFunc, len(r.dec)+1)
+	for k, f := range r.dec {
+		decs[k] = f
+	}
+	if enc != nil {
+		encs[t] = enc
+	} else {
+		delete(encs, t)
+	}
+	if dec != nil {
+		decs[t] = dec
+	} else {
+		delete(decs, t)
+	}
+	r.enc, r.dec = encs, decs
+	r.Unlock()
+
+	// encoders built before are stale
+	defaultEncoders.reset()
+}
+
+func globalEncodeFunc(t reflect.Type) EncodeFunc {
+	registeredTypes.RLock()
+	f := registeredTypes.enc[t]
+	registeredTypes.RUnlock()
+	return f
+}
+
+func globalDecodeFuncs() map[reflect.Type]DecodeFunc {
+	registeredTypes.RLock()
+	m := registeredTypes.dec
+	registeredTypes.RUnlock()
+	return m
+}
+
+// A registeredUnmarshaler is an Unmarshaler calling a DecodeFunc on v.
+type registeredUnmarshaler struct {
+	f DecodeFunc
+	v reflect.Value
+}
+
+func (u registeredUnmarshaler) UnmarshalMCPACK(data []byte) error {
+	return u.f(data, u.v)
+}
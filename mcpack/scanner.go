@@ -0,0 +1,184 @@
+package mcpack
+
+import (
+	"fmt"
+)
+
+// vlenSize returns the number of bytes taken by the content length
+// field of an item of type typ. Fixed size items carry their content
+// length in the low bits of the type and have no length field.
+func vlenSize(typ byte) int {
+	switch {
+	case typ&^MCPACKV2_FIXED_ITEM != 0:
+		return 0
+	case typ&MCPACKV2_SHORT_ITEM != 0:
+		return 1
+	default:
+		return 4
+	}
+}
+
+func validType(typ byte) bool {
+	switch typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY,
+		MCPACKV2_STRING, MCPACKV2_SHORT_STRING,
+		MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY,
+		MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64,
+		MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64,
+		MCPACKV2_BOOL, MCPACKV2_FLOAT, MCPACKV2_DOUBLE, MCPACKV2_DATE,
+		MCPACKV2_NULL:
+		return true
+	}
+	return false
+}
+
+// isDeleted reports whether an item of type typ has been deleted in
+// place. Deleting an item sets the MCPACKV2_DELETED_ITEM bits of its
+// type, which keeps its size class so that it can still be skipped.
+func isDeleted(typ byte) bool {
+	return typ&MCPACKV2_DELETED_ITEM == MCPACKV2_DELETED_ITEM
+}
+
+func syntaxError(off int, msg string) error {
+	return &SyntaxError{msg, off}
+}
+
+// itemHeader parses the header of the item starting at data[off].
+// It returns the item type, the key length (including the trailing
+// 0x00), the content length and the offset of the content.
+//
+// type(1) | klen(1) | vlen(0/1/4) | key(klen) | content(vlen)
+func itemHeader(data []byte, off int) (typ byte, klen, vlen, voff int, err error) {
+	if off+2 > len(data) {
+		return 0, 0, 0, 0, syntaxError(off, "unexpected end of item header")
+	}
+	typ = data[off]
+	klen = int(data[off+1])
+	voff = off + 2
+	switch n := vlenSize(typ); n {
+	case 0:
+		vlen = int(typ &^ MCPACKV2_FIXED_ITEM)
+	case 1:
+		if voff+1 > len(data) {
+			return 0, 0, 0, 0, syntaxError(off, "unexpected end of item header")
+		}
+		vlen = int(data[voff])
+		voff++
+	default:
+		if voff+4 > len(data) {
+			return 0, 0, 0, 0, syntaxError(off, "unexpected end of item header")
+		}
+		vlen = int(Uint32(data[voff:]))
+		voff += 4
+	}
+	voff += klen
+	if voff > len(data) || vlen > len(data)-voff {
+		return 0, 0, 0, 0, syntaxError(off, "item exceeds end of data")
+	}
+	return typ, klen, vlen, voff, nil
+}
+
+// stripKey returns the item b with its key removed. b is returned
+// unchanged if it has no key.
+func stripKey(b []byte) []byte {
+	klen := int(b[1])
+	if klen == 0 {
+		return b
+	}
+	hlen := 2 + vlenSize(b[0])
+	out := make([]byte, len(b)-klen)
+	copy(out, b[:hlen])
+	out[1] = 0
+	copy(out[hlen:], b[hlen+klen:])
+	return out
+}
+
+// Valid reports whether data holds exactly one well-formed mcpack item.
+func Valid(data []byte) bool {
+	return checkValid(data) == nil
+}
+
+// CountDeleted checks that data holds exactly one well-formed mcpack
+// item and returns the number of object members and array elements in
+// it that have been deleted in place.
+func CountDeleted(data []byte) (int, error) {
+	var s scanner
+	if err := s.check(data); err != nil {
+		return 0, err
+	}
+	return s.deleted, nil
+}
+
+// checkValid verifies that data holds exactly one well-formed item.
+func checkValid(data []byte) error {
+	var s scanner
+	return s.check(data)
+}
+
+// scanner validates encoded items.
+type scanner struct {
+	deleted int // number of deleted items skipped
+	depth   int // number of containers being checked
+}
+
+func (s *scanner) check(data []byte) error {
+	end, err := s.item(data, 0)
+	if err != nil {
+		return err
+	}
+	if end != len(data) {
+		return syntaxError(end, "data after top-level item")
+	}
+	return nil
+}
+
+// item validates the item starting at data[off] and returns the offset
+// just past it.
+func (s *scanner) item(data []byte, off int) (int, error) {
+	typ, klen, vlen, voff, err := itemHeader(data, off)
+	if err != nil {
+		return 0, err
+	}
+	if isDeleted(typ) {
+		s.deleted++
+		return voff + vlen, nil
+	}
+	if !validType(typ) {
+		return 0, syntaxError(off, fmt.Sprintf("invalid item type 0x%02x", typ))
+	}
+	if klen > 0 && data[voff-1] != 0 {
+		return 0, syntaxError(voff-1, "key not terminated by 0x00")
+	}
+	end := voff + vlen
+	switch typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY:
+		if vlen < 4 {
+			return 0, syntaxError(off, "container too short for member count")
+		}
+		if s.depth++; s.depth > DefaultMaxDepth {
+			return 0, &DepthError{Limit: DefaultMaxDepth, Offset: off}
+		}
+		n := int(Uint32(data[voff:]))
+		p := voff + 4
+		for i := 0; i < n; i++ {
+			if p >= end {
+				return 0, syntaxError(p, "fewer members than declared")
+			}
+			if typ == MCPACKV2_OBJECT && !isDeleted(data[p]) && p+1 < end && data[p+1] == 0 {
+				return 0, syntaxError(p, "object member without key")
+			}
+			if p, err = s.item(data[:end], p); err != nil {
+				return 0, err
+			}
+		}
+		if p != end {
+			return 0, syntaxError(p, "container length mismatch")
+		}
+		s.depth--
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
+		if vlen == 0 || data[end-1] != 0 {
+			return 0, syntaxError(voff, "string not terminated by 0x00")
+		}
+	}
+	return end, nil
+}
@@ -0,0 +1,263 @@
+package mcpack
+
+import (
+	"errors"
+	"io"
+	"reflect"
+)
+
+// A Decoder reads and decodes mcpack items from an input stream.
+//
+// The options set on a Decoder apply to the values it decodes itself,
+// including the types with methods generated by mcpackgen, but not to
+// other Unmarshalers, which only receive the encoded item.
+type Decoder struct {
+	r   io.Reader
+	d   decodeState
+	err error
+	off int // offset in the stream of the next item
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder reads exactly one top-level item per call to Decode and
+// never reads past its end, so r may be shared with other readers.
+//
+// Each item is read whole before it is decoded, and by default its
+// length and the memory allocated for its values are not limited.
+// Input that is not trusted, such as that read from a network
+// connection, should be decoded with a limit set by SetMaxAlloc.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next mcpack item from its input and stores it in
+// the value pointed to by v. It returns io.EOF when the input is
+// exhausted at an item boundary.
+func (dec *Decoder) Decode(v interface{}) error {
+	if dec.err != nil {
+		return dec.err
+	}
+	data, err := dec.readItem()
+	if err != nil {
+		dec.err = err
+		return err
+	}
+	dec.off += len(data)
+	dec.d.init(data)
+	return dec.d.unmarshal(v)
+}
+
+// ConvertBoolInt causes the Decoder to accept bool items into integer
+// values, as 0 or 1, and integer items into bool values, as false if
+// zero and true otherwise.
+func (dec *Decoder) ConvertBoolInt() {
+	dec.d.convertBoolInt = true
+}
+
+// DisallowUnknownFields causes the Decoder to return an
+// *UnknownFieldError when an object member matches no exported field
+// of the struct it is decoded into. Decoding goes on with the other
+// members.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.d.disallowUnknownFields = true
+}
+
+// CaseSensitive causes the Decoder to match object keys to struct
+// fields only when they are equal, rather than falling back to a case
+// insensitive match.
+func (dec *Decoder) CaseSensitive() {
+	dec.d.caseSensitive = true
+}
+
+// UseNumber causes the Decoder to decode numeric items into an
+// interface{} as a Number instead of as the Go type of the item.
+func (dec *Decoder) UseNumber() {
+	dec.d.useNumber = true
+}
+
+// SetMaxDepth limits the depth to which objects and arrays may nest in
+// the items decoded. Deeper data fails with a *DepthError. A limit of 0
+// restores DefaultMaxDepth.
+func (dec *Decoder) SetMaxDepth(n int) {
+	dec.d.maxDepth = n
+}
+
+// SetMaxElements limits the number of members of a single object or
+// array in the items decoded. Larger containers fail with an
+// *ElementsError. A limit of 0 removes it.
+func (dec *Decoder) SetMaxElements(n int) {
+	dec.d.maxElements = n
+}
+
+// SetMaxAlloc limits the memory allocated for the values decoded from a
+// single item, counting strings, slices, maps and new pointers by the
+// size of their contents. Data needing more fails with an *AllocError.
+// Items longer than the limit fail the same way before being read,
+// with the offset of the item in the stream. A limit of 0 removes it.
+func (dec *Decoder) SetMaxAlloc(n int) {
+	dec.d.maxAlloc = n
+}
+
+// RegisterType registers f to decode the values of type t for this
+// Decoder only, as RegisterType does globally, taking precedence over
+// a global registration. A nil f decodes t by the usual rules even if
+// it is registered globally.
+func (dec *Decoder) RegisterType(t reflect.Type, f DecodeFunc) {
+	if dec.d.types == nil {
+		dec.d.types = make(map[reflect.Type]DecodeFunc)
+	}
+	dec.d.types[t] = f
+}
+
+// readItem reads one complete item from the input. A fresh buffer is
+// returned on every call since decoded byte slices refer to it.
+func (dec *Decoder) readItem() ([]byte, error) {
+	// type(1) | klen(1) | vlen(0/1/4)
+	var hdr [6]byte
+	if _, err := io.ReadFull(dec.r, hdr[:2]); err != nil {
+		return nil, err
+	}
+	typ := hdr[0]
+	klen := int(hdr[1])
+	hlen := 2 + vlenSize(typ)
+	if _, err := io.ReadFull(dec.r, hdr[2:hlen]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	var vlen int
+	switch hlen - 2 {
+	case 0:
+		vlen = int(typ &^ MCPACKV2_FIXED_ITEM)
+	case 1:
+		vlen = int(Uint8(hdr[2:]))
+	default:
+		vlen = int(Uint32(hdr[2:]))
+	}
+
+	if max := dec.d.maxAlloc; max > 0 && hlen+klen+vlen > max {
+		return nil, &AllocError{Limit: max, Offset: dec.off}
+	}
+	// the content is read in growing chunks, so that a corrupt or
+	// hostile vlen costs only as much memory as the bytes received
+	n := hlen + klen + vlen
+	data := make([]byte, hlen, min(n, readChunk))
+	copy(data, hdr[:hlen])
+	for len(data) < n {
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		m, err := io.ReadFull(dec.r, data[len(data):min(n, cap(data))])
+		data = data[:len(data)+m]
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	return data, nil
+}
+
+// readChunk is the size of the buffer first allocated by readItem.
+const readChunk = 4096
+
+func min(l, r int) int {
+	if l < r {
+		return l
+	}
+	return r
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// An Encoder writes mcpack items to an output stream.
+type Encoder struct {
+	w   io.Writer
+	err error
+	buf []byte // reused across calls to Encode
+
+	opts encOpts
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the mcpack encoding of v to the stream. The encoding
+// is built in a buffer kept by enc, so once the buffer has grown to fit
+// the values written, Encode allocates no memory of its own.
+func (enc *Encoder) Encode(v interface{}) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	b, err := appendMarshal(enc.buf[:0], v, enc.opts)
+	if err != nil {
+		return err
+	}
+	enc.buf = b
+	if _, err := enc.w.Write(b); err != nil {
+		enc.err = err
+		return err
+	}
+	return nil
+}
+
+// SetCompactInts controls whether 8 and 16 bit integers are written as
+// the INT8, INT16, UINT8 and UINT16 item types. These are not supported
+// by libmcpack, so by default they are widened to 32 bits. Decoding
+// always accepts them.
+func (enc *Encoder) SetCompactInts(on bool) {
+	enc.opts.compactInts = on
+}
+
+// SetSkipUnsupported controls whether values of types mcpack cannot
+// represent, such as channels, functions and complex numbers, are left
+// out of the output. By default encoding them fails with an
+// *UnsupportedTypeError. Containers count only the members written.
+// A top-level value that would be left out entirely still fails.
+func (enc *Encoder) SetSkipUnsupported(on bool) {
+	enc.opts.skipUnsupported = on
+}
+
+// RegisterType registers f to encode the values of type t for this
+// Encoder only, as RegisterType does globally, taking precedence over
+// a global registration. A nil f encodes t by the usual rules even if
+// it is registered globally.
+func (enc *Encoder) RegisterType(t reflect.Type, f EncodeFunc) {
+	types := map[reflect.Type]EncodeFunc{t: f}
+	if enc.opts.encoders != nil {
+		for k, f := range enc.opts.encoders.types {
+			if k != t {
+				types[k] = f
+			}
+		}
+	}
+	// start over, as the encoders built so far may not use f
+	enc.opts.encoders = &encoderCache{types: types}
+}
+
+// RawMessage is a raw encoded mcpack item without its key. It
+// implements Marshaler and Unmarshaler and can be used to delay
+// decoding or precompute an encoding.
+type RawMessage []byte
+
+// MarshalMCPACK returns m as the mcpack encoding of m. The encoder
+// writes it under the key of the field or map element holding m.
+func (m RawMessage) MarshalMCPACK() ([]byte, error) {
+	if m == nil {
+		return []byte{MCPACKV2_NULL, 0, 0}, nil
+	}
+	return m, nil
+}
+
+// UnmarshalMCPACK sets *m to a copy of data.
+func (m *RawMessage) UnmarshalMCPACK(data []byte) error {
+	if m == nil {
+		return errors.New("mcpack.RawMessage: UnmarshalMCPACK on nil pointer")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
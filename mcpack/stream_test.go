@@ -0,0 +1,228 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+var streamTests = []interface{}{
+	&T{A: true, X: "x", Y: 1},
+	&U{Alphabet: "a-z"},
+	&W{S: string(longVItem[:]), V: 1},
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range streamTests {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	for i, v := range streamTests {
+		out := reflect.New(reflect.TypeOf(v).Elem())
+		if err := dec.Decode(out.Interface()); err != nil {
+			t.Fatalf("#%d Decode: %v", i, err)
+		}
+		if !reflect.DeepEqual(out.Interface(), v) {
+			t.Errorf("#%d mismatch, got %#+v, expect %#+v", i, out.Interface(), v)
+		}
+	}
+	if err := dec.Decode(new(T)); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	b, err := Marshal(&U{Alphabet: "a-z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecoder(bytes.NewReader(b[:len(b)-1]))
+	if err := dec.Decode(new(U)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderHugeVlen(t *testing.T) {
+	// an object claiming 2GB of content followed by a few bytes
+	in := []byte{MCPACKV2_OBJECT, 0, 0xff, 0xff, 0xff, 0x7f, 1, 2, 3}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := NewDecoder(bytes.NewReader(in)).Decode(new(interface{}))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes", n)
+	}
+}
+
+type Envelope struct {
+	Type string
+	Msg  RawMessage
+}
+
+func TestRawMessage(t *testing.T) {
+	b, err := Marshal(&struct {
+		Type string
+		Msg  *U
+	}{"u", &U{Alphabet: "a-z"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env Envelope
+	if err := Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != "u" || env.Msg[1] != 0 {
+		t.Fatalf("got %#v, expect a keyless raw item", env)
+	}
+	var u U
+	if err := Unmarshal(env.Msg, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Alphabet != "a-z" {
+		t.Errorf("got %#v", u)
+	}
+
+	// re-encoding puts the raw item back under its key
+	out, err := Marshal(&env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, b) {
+		t.Errorf("got %#v, expect %#v", out, b)
+	}
+
+	out, err = Marshal(&Envelope{Type: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m["Msg"]; !ok || v != nil {
+		t.Errorf("expected null Msg, got %#v", m)
+	}
+}
+
+type Timeout struct {
+	D    time.Duration
+	P, Z *time.Duration
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func encodeDuration(v reflect.Value) ([]byte, error) {
+	return Marshal(time.Duration(v.Int()).String())
+}
+
+func decodeDuration(data []byte, v reflect.Value) error {
+	var s string
+	if err := Unmarshal(data, &s); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	v.SetInt(int64(d))
+	return err
+}
+
+func TestRegisterType(t *testing.T) {
+	RegisterType(durationType, encodeDuration, decodeDuration)
+	defer RegisterType(durationType, nil, nil)
+
+	p := 2 * time.Second
+	in := Timeout{D: 1500 * time.Millisecond, P: &p}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"D": "1.5s\x00", "P": "2s\x00"} {
+		m, _ := Get(b, key)
+		if m.Type != MCPACKV2_SHORT_STRING || string(m.Value) != want {
+			t.Errorf("%s: got %#x %q, expect %q", key, m.Type, m.Value, want)
+		}
+	}
+	if m, _ := Get(b, "Z"); m.Type != MCPACKV2_NULL {
+		t.Errorf("Z: got %#x, expect NULL", m.Type)
+	}
+	var out Timeout
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %#v, expect %#v", out, in)
+	}
+
+	// a nil function registered with an Encoder or Decoder masks the
+	// global one
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.RegisterType(durationType, nil)
+	if err := enc.Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := Get(buf.Bytes(), "D"); d.Type != MCPACKV2_INT64 {
+		t.Errorf("D: got %#x, expect INT64", d.Type)
+	}
+	dec := NewDecoder(&buf)
+	dec.RegisterType(durationType, nil)
+	out = Timeout{}
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("got %#v, expect %#v", out, in)
+	}
+}
+
+type Celsius float64
+
+func TestRegisterTypeConcurrent(t *testing.T) {
+	typ := reflect.TypeOf(Celsius(0))
+	defer RegisterType(typ, nil, nil)
+	in := map[string][]Celsius{"t": {36.6}}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := Marshal(in); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		RegisterType(typ, func(v reflect.Value) ([]byte, error) {
+			return Marshal(v.Float())
+		}, nil)
+		RegisterType(typ, nil, nil)
+		runtime.Gosched()
+	}
+	close(done)
+	wg.Wait()
+}
@@ -5,14 +5,26 @@
 package mcpack
 
 import (
+	"reflect"
 	"strings"
 )
 
-// tagOptions is the string following a comma in a struct field's "json"
-// tag, or the empty string. It does not include the leading comma.
+// tagOptions is the string following a comma in a struct field's
+// "mcpack" or "json" tag, or the empty string. It does not include the
+// leading comma.
 type tagOptions string
 
-// parseTag splits a struct field's json tag into its name and
+// fieldTag returns the tag of a struct field. The "mcpack" tag is used
+// if present, so that the wire name may differ from the JSON one;
+// otherwise the "json" tag is used.
+func fieldTag(sf reflect.StructField) string {
+	if tag, ok := sf.Tag.Lookup("mcpack"); ok {
+		return tag
+	}
+	return sf.Tag.Get("json")
+}
+
+// parseTag splits a struct field's tag into its name and
 // comma-separated options.
 func parseTag(tag string) (string, tagOptions) {
 	if idx := strings.Index(tag, ","); idx != -1 {
@@ -42,3 +54,23 @@ func (o tagOptions) Contains(optionName string) bool {
 	}
 	return false
 }
+
+// wireType returns the item type forced by the options, or
+// MCPACKV2_INVALID if the field type decides it.
+//
+//	binary: a string is written as BINARY
+//	int64, int32: an integer is written as INT64 or INT32
+//	date: a time.Time is written as DATE
+func (o tagOptions) wireType() byte {
+	switch {
+	case o.Contains("binary"):
+		return MCPACKV2_BINARY
+	case o.Contains("int64"):
+		return MCPACKV2_INT64
+	case o.Contains("int32"):
+		return MCPACKV2_INT32
+	case o.Contains("date"):
+		return MCPACKV2_DATE
+	}
+	return MCPACKV2_INVALID
+}
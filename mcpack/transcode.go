@@ -0,0 +1,312 @@
+package mcpack
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// A NumberRule selects the item types FromJSON writes JSON numbers as.
+type NumberRule int
+
+const (
+	// NumberSmallest writes integers as the smallest of INT32, INT64
+	// and UINT64 that holds them, and other numbers as DOUBLE.
+	NumberSmallest NumberRule = iota
+	// NumberInt64 writes integers as INT64, or UINT64 if they are
+	// too large, and other numbers as DOUBLE.
+	NumberInt64
+	// NumberDouble writes all numbers as DOUBLE.
+	NumberDouble
+)
+
+// A BinaryRule selects how ToJSON writes BINARY items.
+type BinaryRule int
+
+const (
+	// BinaryBase64 writes BINARY items as strings holding their
+	// standard base64 encoding, as encoding/json does for []byte.
+	BinaryBase64 BinaryRule = iota
+	// BinaryString writes BINARY items as strings holding their bytes,
+	// with invalid UTF-8 replaced by U+FFFD.
+	BinaryString
+)
+
+// A Transcoder converts documents between JSON and mcpack without
+// going through Go values. Member order is preserved in both
+// directions. Each rule applies to one direction only: Numbers and
+// CompactInts to FromJSON, Binary to ToJSON.
+//
+// Converting an mcpack document to JSON and back yields the same bytes
+// if it holds only objects, arrays and STRING, BOOL and NULL items,
+// integers of the types Numbers selects for them, and DOUBLE items that
+// are not integral. Other items do not survive the round trip: integers
+// of other widths and integral DOUBLE and FLOAT items come back as the
+// types Numbers selects, other FLOAT items as DOUBLE, and BINARY and
+// DATE items as the STRING items ToJSON writes for them. The key of the
+// top-level item and deleted items are dropped.
+//
+// The zero Transcoder is ready to use and is what FromJSON and ToJSON
+// use.
+type Transcoder struct {
+	// Numbers selects the item types of JSON numbers.
+	Numbers NumberRule
+	// CompactInts lets NumberSmallest also use INT8 and INT16, as
+	// Encoder.SetCompactInts does.
+	CompactInts bool
+	// Binary selects how BINARY items are written to JSON.
+	Binary BinaryRule
+}
+
+// FromJSON reads one JSON value from r and returns its mcpack encoding
+// using the default Transcoder.
+func FromJSON(r io.Reader) ([]byte, error) {
+	var t Transcoder
+	return t.FromJSON(r)
+}
+
+// ToJSON writes the mcpack item in data to w as JSON using the default
+// Transcoder.
+func ToJSON(data []byte, w io.Writer) error {
+	var t Transcoder
+	return t.ToJSON(data, w)
+}
+
+// FromJSON reads one JSON value from r and returns its mcpack encoding.
+// Objects become OBJECT items, arrays ARRAY items, strings STRING items,
+// booleans BOOL items, null NULL items and numbers follow t.Numbers.
+// Strings are never turned back into BINARY or DATE items.
+func (t Transcoder) FromJSON(r io.Reader) (b []byte, err error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	e := &encodeState{encOpts: encOpts{compactInts: t.CompactInts}}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+	t.fromJSON(dec, e, "")
+	return e.data[:e.off], nil
+}
+
+func (t Transcoder) fromJSON(dec *json.Decoder, e *encodeState, k string) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		e.error(err)
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		typ := byte(MCPACKV2_ARRAY)
+		if tok == '{' {
+			typ = MCPACKV2_OBJECT
+		}
+		// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | count(4)
+		e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+		//type(1)
+		e.setType(typ)
+		//klen(1)
+		l := e.setKeyLen(k)
+		//vlen defer
+		vlenpos := e.off
+		e.off += 4
+		//key(k[:l]) | 0x00
+		e.setKey(k, l)
+		//vpos defer
+		vpos := e.off
+		e.off += 4
+		n := 0
+		for ; dec.More(); n++ {
+			var key string
+			if typ == MCPACKV2_OBJECT {
+				ktok, err := dec.Token()
+				if err != nil {
+					e.error(err)
+				}
+				if key = ktok.(string); key == "" {
+					e.error(errors.New("mcpack: empty JSON object key"))
+				}
+			}
+			t.fromJSON(dec, e, key)
+		}
+		if _, err := dec.Token(); err != nil {
+			e.error(err)
+		}
+		//count
+		PutInt32(e.data[vpos:], int32(n))
+		//vlen
+		PutInt32(e.data[vlenpos:], int32(e.off-vpos))
+	case json.Number:
+		e.reflectValue(k, reflect.ValueOf(t.number(tok)))
+	case nil:
+		nilEncoder(e, k, reflect.Value{})
+	default:
+		e.reflectValue(k, reflect.ValueOf(tok))
+	}
+}
+
+// number returns the Go value to encode the JSON number n as.
+func (t Transcoder) number(n json.Number) interface{} {
+	if t.Numbers != NumberDouble {
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			switch {
+			case t.Numbers == NumberInt64:
+				return i
+			case t.CompactInts && i >= math.MinInt8 && i <= math.MaxInt8:
+				return int8(i)
+			case t.CompactInts && i >= math.MinInt16 && i <= math.MaxInt16:
+				return int16(i)
+			case i >= math.MinInt32 && i <= math.MaxInt32:
+				return int32(i)
+			}
+			return i
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return u
+		}
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// ToJSON writes the mcpack item in data to w as JSON. Keys of the top
+// level item and deleted items are dropped. DATE items are written as
+// RFC 3339 strings. BINARY items follow t.Binary.
+func (t Transcoder) ToJSON(data []byte, w io.Writer) error {
+	if err := checkValid(data); err != nil {
+		return err
+	}
+	jw := &jsonWriter{Writer: bufio.NewWriter(w), t: t}
+	if err := jw.item(data, 0); err != nil {
+		return err
+	}
+	return jw.Flush()
+}
+
+type jsonWriter struct {
+	*bufio.Writer
+	t       Transcoder
+	scratch [64]byte
+}
+
+// item writes the valid item at data[off] and its members.
+func (w *jsonWriter) item(data []byte, off int) error {
+	typ, _, vlen, voff, _ := itemHeader(data, off)
+	it := Item{Type: typ, Value: data[voff : voff+vlen]}
+	switch typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY:
+		open, close := byte('['), byte(']')
+		if typ == MCPACKV2_OBJECT {
+			open, close = '{', '}'
+		}
+		w.WriteByte(open)
+		n := int(Uint32(data[voff:]))
+		p := voff + 4
+		first := true
+		for i := 0; i < n; i++ {
+			_, klen, mvlen, mvoff, _ := itemHeader(data, p)
+			if !isDeleted(data[p]) {
+				if !first {
+					w.WriteByte(',')
+				}
+				first = false
+				if typ == MCPACKV2_OBJECT {
+					w.string(data[mvoff-klen : mvoff-1])
+					w.WriteByte(':')
+				}
+				if err := w.item(data, p); err != nil {
+					return err
+				}
+			}
+			p = mvoff + mvlen
+		}
+		w.WriteByte(close)
+	case MCPACKV2_STRING, MCPACKV2_SHORT_STRING:
+		w.string(it.Value[:len(it.Value)-1])
+	case MCPACKV2_BINARY, MCPACKV2_SHORT_BINARY:
+		if w.t.Binary == BinaryString {
+			w.string(it.Value)
+			break
+		}
+		w.WriteByte('"')
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		enc.Write(it.Value)
+		enc.Close()
+		w.WriteByte('"')
+	case MCPACKV2_INT8, MCPACKV2_INT16, MCPACKV2_INT32, MCPACKV2_INT64:
+		w.Write(strconv.AppendInt(w.scratch[:0], it.signed(), 10))
+	case MCPACKV2_UINT8, MCPACKV2_UINT16, MCPACKV2_UINT32, MCPACKV2_UINT64:
+		w.Write(strconv.AppendUint(w.scratch[:0], it.unsigned(), 10))
+	case MCPACKV2_FLOAT, MCPACKV2_DOUBLE:
+		f := it.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("mcpack: unsupported JSON value %v at offset %d", f, off)
+		}
+		bits := 64
+		if typ == MCPACKV2_FLOAT {
+			bits = 32
+		}
+		w.Write(strconv.AppendFloat(w.scratch[:0], f, 'g', -1, bits))
+	case MCPACKV2_BOOL:
+		w.Write(strconv.AppendBool(w.scratch[:0], it.Bool()))
+	case MCPACKV2_DATE:
+		w.WriteByte('"')
+		w.Write(it.Time().UTC().AppendFormat(w.scratch[:0], time.RFC3339))
+		w.WriteByte('"')
+	case MCPACKV2_NULL:
+		w.WriteString("null")
+	}
+	return nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// string writes s as a JSON string.
+func (w *jsonWriter) string(s []byte) {
+	w.WriteByte('"')
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				w.WriteByte('\\')
+				w.WriteByte(c)
+			case c == '\n':
+				w.WriteString(`\n`)
+			case c == '\r':
+				w.WriteString(`\r`)
+			case c == '\t':
+				w.WriteString(`\t`)
+			case c < 0x20:
+				w.WriteString(`\u00`)
+				w.WriteByte(hexDigits[c>>4])
+				w.WriteByte(hexDigits[c&0xf])
+			default:
+				w.WriteByte(c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			w.WriteString(`\ufffd`)
+		} else {
+			w.Write(s[i : i+size])
+		}
+		i += size
+	}
+	w.WriteByte('"')
+}
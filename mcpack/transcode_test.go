@@ -0,0 +1,147 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+func TestTranscodeJSON(t *testing.T) {
+	const in = `{"z":1,"a":[true,null,"x\"y\n"],"big":9223372036854775808,"f":1.5,"m":{"k":-3000000000}}`
+	data, err := FromJSON(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v Value
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if keys := strings.Join(v.Keys(), ","); keys != "z,a,big,f,m" {
+		t.Errorf("got keys %s", keys)
+	}
+	types := map[string]byte{
+		"z":   MCPACKV2_INT32,
+		"big": MCPACKV2_UINT64,
+		"f":   MCPACKV2_DOUBLE,
+	}
+	for k, typ := range types {
+		if got := v.Get(k).Type(); got != typ {
+			t.Errorf("%s: got type %#x, expect %#x", k, got, typ)
+		}
+	}
+	if got := v.Get("m").Get("k").Type(); got != MCPACKV2_INT64 {
+		t.Errorf("m.k: got type %#x, expect int64", got)
+	}
+
+	var out bytes.Buffer
+	if err := ToJSON(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != in {
+		t.Errorf("got %s, expect %s", out.String(), in)
+	}
+}
+
+func TestTranscoderRules(t *testing.T) {
+	data, err := Transcoder{Numbers: NumberInt64}.FromJSON(strings.NewReader(`[1,2.5]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v Value
+	Unmarshal(data, &v)
+	if v.Index(0).Type() != MCPACKV2_INT64 || v.Index(1).Type() != MCPACKV2_DOUBLE {
+		t.Errorf("NumberInt64: got types %#x %#x", v.Index(0).Type(), v.Index(1).Type())
+	}
+	data, _ = Transcoder{Numbers: NumberDouble}.FromJSON(strings.NewReader(`[1]`))
+	Unmarshal(data, &v)
+	if v.Index(0).Type() != MCPACKV2_DOUBLE {
+		t.Errorf("NumberDouble: got type %#x", v.Index(0).Type())
+	}
+
+	data, _ = Marshal(struct{ B []byte }{[]byte("hi")})
+	var out bytes.Buffer
+	if err := ToJSON(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"B":"aGk="}` {
+		t.Errorf("BinaryBase64: got %s", out.String())
+	}
+	out.Reset()
+	if err := (Transcoder{Binary: BinaryString}).ToJSON(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"B":"hi"}` {
+		t.Errorf("BinaryString: got %s", out.String())
+	}
+
+	if _, err := FromJSON(strings.NewReader(`{"a":`)); err == nil {
+		t.Error("expected error on truncated JSON")
+	}
+}
+
+func TestTranscodeRoundTrip(t *testing.T) {
+	roundTrip := func(data []byte) []byte {
+		var out bytes.Buffer
+		if err := ToJSON(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		back, err := FromJSON(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return back
+	}
+
+	lossless := []interface{}{
+		map[string]interface{}{
+			"i32": int32(-7),
+			"i64": int64(-3000000000),
+			"u64": uint64(1 << 63),
+			"f":   1.5,
+			"s":   "x\"y\n",
+			"b":   true,
+			"n":   nil,
+			"a":   []interface{}{int32(1), []interface{}{}, map[string]interface{}{}},
+			"l":   strings.Repeat("long", 100),
+		},
+		[]interface{}{"a", false},
+	}
+	for _, in := range lossless {
+		data, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back := roundTrip(data); !bytes.Equal(back, data) {
+			t.Errorf("%v: got %#v, expect %#v", in, back, data)
+		}
+	}
+
+	// the losses documented on Transcoder
+	lossy := []struct {
+		in   interface{}
+		back interface{}
+	}{
+		{int8(1), int32(1)},
+		{uint32(2), int32(2)},
+		{int64(3), int32(3)},
+		{2.0, int32(2)},
+		{float32(0.5), 0.5},
+		{[]byte("hi"), "aGk="},
+		{time.Unix(1500000000, 0), "2017-07-14T02:40:00Z"},
+	}
+	for _, tt := range lossy {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetCompactInts(true)
+		if err := enc.Encode(tt.in); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := Marshal(tt.back)
+		if back := roundTrip(buf.Bytes()); !bytes.Equal(back, want) {
+			t.Errorf("%#v: got %#v, expect %#v", tt.in, back, want)
+		}
+	}
+}
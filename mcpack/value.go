@@ -0,0 +1,363 @@
+package mcpack
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+// A Value is a node of an mcpack document. Unlike decoding into
+// interface{}, it keeps the exact item type, the member keys and their
+// order, so that a document can be inspected, edited and encoded again
+// faithfully. A Value decoded by Unmarshal and left unmodified encodes
+// to the very bytes it was decoded from.
+//
+// The zero Value holds no item and encodes as NULL; Values are obtained
+// from Unmarshal, NewValue, or the accessors of another Value. The
+// accessors are safe to call on a nil *Value and return zero results,
+// so that lookups can be chained.
+//
+// Members returned by Get and Index are part of their container and
+// may be edited in place. Set, SetIndex and Append store copies of
+// their argument.
+type Value struct {
+	typ   byte
+	key   string
+	raw   []byte   // encoded item as decoded, nil once modified
+	val   []byte   // content of scalar items
+	elems []*Value // members of objects and elements of arrays
+}
+
+// NewValue returns the Value of the mcpack encoding of x.
+func NewValue(x interface{}) (*Value, error) {
+	b, err := Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	v := new(Value)
+	if err := v.UnmarshalMCPACK(b); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// UnmarshalMCPACK sets v to the document held by data.
+func (v *Value) UnmarshalMCPACK(data []byte) error {
+	if v == nil {
+		return errors.New("mcpack.Value: UnmarshalMCPACK on nil pointer")
+	}
+	if err := checkValid(data); err != nil {
+		return err
+	}
+	data = append([]byte(nil), data...)
+	*v = *parseValue(data, 0)
+	return nil
+}
+
+// parseValue returns the Value of the valid item at data[off].
+func parseValue(data []byte, off int) *Value {
+	typ, klen, vlen, voff, _ := itemHeader(data, off)
+	v := &Value{typ: typ, raw: data[off : voff+vlen]}
+	if klen > 0 {
+		v.key = string(data[voff-klen : voff-1])
+	}
+	switch typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY:
+		n := int(Uint32(data[voff:]))
+		v.elems = make([]*Value, 0, n)
+		p := voff + 4
+		for i := 0; i < n; i++ {
+			if isDeleted(data[p]) {
+				_, _, vlen, voff, _ := itemHeader(data, p)
+				p = voff + vlen
+				continue
+			}
+			elem := parseValue(data, p)
+			v.elems = append(v.elems, elem)
+			p += len(elem.raw)
+		}
+	default:
+		v.val = data[voff : voff+vlen]
+	}
+	return v
+}
+
+// MarshalMCPACK returns the encoding of v. The zero Value encodes as
+// NULL.
+func (v Value) MarshalMCPACK() (b []byte, err error) {
+	if v.typ == MCPACKV2_INVALID {
+		return []byte{MCPACKV2_NULL, 0, 0}, nil
+	}
+	if v.clean() && v.raw[1] == 0 {
+		return v.raw, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(runtime.Error); ok {
+				panic(r)
+			}
+			err = r.(error)
+		}
+	}()
+	e := &encodeState{}
+	v.encode(e, "")
+	return e.data[:e.off], nil
+}
+
+// clean reports whether neither v nor any of its descendants have been
+// modified since v was decoded.
+func (v *Value) clean() bool {
+	if v.raw == nil {
+		return false
+	}
+	for _, elem := range v.elems {
+		if !elem.clean() {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *Value) encode(e *encodeState, k string) {
+	if v.clean() {
+		e.setItem(k, v.raw)
+		return
+	}
+	switch v.typ {
+	case MCPACKV2_OBJECT, MCPACKV2_ARRAY:
+		// type(1) | klen(1) | vlen(4) | key(len(k)) | 0x00 | count(4)
+		e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + 4)
+		//type(1)
+		e.setType(v.typ)
+		//klen(1)
+		l := e.setKeyLen(k)
+		//vlen defer
+		vlenpos := e.off
+		e.off += 4
+		//key(k[:l]) | 0x00
+		e.setKey(k, l)
+		//vpos defer
+		vpos := e.off
+		//count(4)
+		PutInt32(e.data[e.off:], int32(len(v.elems)))
+		e.off += 4
+		//elem
+		for _, elem := range v.elems {
+			elem.encode(e, elem.key)
+		}
+		//vlen
+		PutInt32(e.data[vlenpos:], int32(e.off-vpos))
+	default:
+		// type(1) | klen(1) | vlen(0/1/4) | key(len(k)) | 0x00 | value
+		e.resizeIfNeeded(1 + 1 + 4 + len(k) + 1 + len(v.val))
+		//type(1)
+		e.setType(v.typ)
+		//klen(1)
+		l := e.setKeyLen(k)
+		//vlen
+		switch vlenSize(v.typ) {
+		case 1:
+			PutUint8(e.data[e.off:], uint8(len(v.val)))
+			e.off++
+		case 4:
+			PutUint32(e.data[e.off:], uint32(len(v.val)))
+			e.off += 4
+		}
+		//key(k[:l]) | 0x00
+		e.setKey(k, l)
+		//value
+		e.off += copy(e.data[e.off:], v.val)
+	}
+}
+
+// Type returns the item type of v, such as MCPACKV2_INT32, or
+// MCPACKV2_INVALID if v is nil.
+func (v *Value) Type() byte {
+	if v == nil {
+		return MCPACKV2_INVALID
+	}
+	return v.typ
+}
+
+// Key returns the key of v within its object, or "" for array elements
+// and top-level values.
+func (v *Value) Key() string {
+	if v == nil {
+		return ""
+	}
+	return v.key
+}
+
+// Len returns the number of members of an object or array, and 0 for
+// other values.
+func (v *Value) Len() int {
+	if v == nil {
+		return 0
+	}
+	return len(v.elems)
+}
+
+// Index returns the i'th member of an object or array, or nil if there
+// is no such member. The member is part of v, so that editing it edits
+// v.
+func (v *Value) Index(i int) *Value {
+	if v == nil || i < 0 || i >= len(v.elems) {
+		return nil
+	}
+	return v.elems[i]
+}
+
+// Get returns the first member of an object with the given key, or nil
+// if there is none. The member is part of v, as for Index.
+func (v *Value) Get(key string) *Value {
+	if i := v.lookup(key); i >= 0 {
+		return v.elems[i]
+	}
+	return nil
+}
+
+func (v *Value) lookup(key string) int {
+	if v == nil || v.typ != MCPACKV2_OBJECT {
+		return -1
+	}
+	for i, elem := range v.elems {
+		if elem.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Keys returns the keys of the members of an object, in order.
+func (v *Value) Keys() []string {
+	if v == nil || v.typ != MCPACKV2_OBJECT {
+		return nil
+	}
+	keys := make([]string, len(v.elems))
+	for i, elem := range v.elems {
+		keys[i] = elem.key
+	}
+	return keys
+}
+
+// item returns the content of a scalar v as an Item.
+func (v *Value) item() Item {
+	if v == nil {
+		return Item{}
+	}
+	return Item{Type: v.typ, Value: v.val}
+}
+
+// Int returns the value of an integer item, as Item.Int does.
+func (v *Value) Int() (int64, error) { return v.item().Int() }
+
+// Uint returns the value of an integer item, as Item.Uint does.
+func (v *Value) Uint() (uint64, error) { return v.item().Uint() }
+
+// Float returns the value of a FLOAT or DOUBLE item, and 0 for other
+// values.
+func (v *Value) Float() float64 { return v.item().Float() }
+
+// Bool returns the value of a BOOL item, and false for other values.
+func (v *Value) Bool() bool { return v.item().Bool() }
+
+// Str returns the value of a STRING item, and "" for other values.
+func (v *Value) Str() string { return v.item().Str() }
+
+// Bytes returns the value of a BINARY item, and nil for other values.
+// The returned slice must not be modified.
+func (v *Value) Bytes() []byte { return v.item().Bytes() }
+
+// Time returns the value of a DATE item, and the zero time.Time for
+// other values.
+func (v *Value) Time() time.Time { return v.item().Time() }
+
+// IsNull reports whether v is a NULL item.
+func (v *Value) IsNull() bool {
+	return v.Type() == MCPACKV2_NULL
+}
+
+var (
+	errNotObject = errors.New("mcpack.Value: not an object")
+	errNotArray  = errors.New("mcpack.Value: not an array")
+)
+
+// Set sets the member of an object with the given key to a copy of x,
+// replacing the first member with that key if any and appending a new
+// member otherwise. Later changes to x do not affect v; the member is
+// edited through Get instead.
+func (v *Value) Set(key string, x *Value) error {
+	if v.Type() != MCPACKV2_OBJECT {
+		return errNotObject
+	}
+	if len(key) > MCPACKV2_KEY_MAX_LEN || key == "" {
+		return errors.New("mcpack.Value: invalid key length")
+	}
+	elem := x.member(key)
+	if i := v.lookup(key); i >= 0 {
+		v.elems[i] = elem
+	} else {
+		v.elems = append(v.elems, elem)
+	}
+	v.raw = nil
+	return nil
+}
+
+// Delete removes the first member of an object with the given key and
+// reports whether there was one.
+func (v *Value) Delete(key string) bool {
+	i := v.lookup(key)
+	if i < 0 {
+		return false
+	}
+	v.elems = append(v.elems[:i], v.elems[i+1:]...)
+	v.raw = nil
+	return true
+}
+
+// SetIndex replaces the i'th element of an array with a copy of x.
+func (v *Value) SetIndex(i int, x *Value) error {
+	if v.Type() != MCPACKV2_ARRAY {
+		return errNotArray
+	}
+	if i < 0 || i >= len(v.elems) {
+		return errors.New("mcpack.Value: index out of range")
+	}
+	v.elems[i] = x.member("")
+	v.raw = nil
+	return nil
+}
+
+// Append adds a copy of x to the end of an array.
+func (v *Value) Append(x *Value) error {
+	if v.Type() != MCPACKV2_ARRAY {
+		return errNotArray
+	}
+	v.elems = append(v.elems, x.member(""))
+	v.raw = nil
+	return nil
+}
+
+// member returns a copy of v keyed by key for storing into a
+// container. A nil v becomes a NULL item.
+func (v *Value) member(key string) *Value {
+	if v == nil {
+		return &Value{typ: MCPACKV2_NULL, key: key, val: []byte{0}}
+	}
+	w := v.clone()
+	w.key = key
+	return w
+}
+
+// clone returns a deep copy of v. Encoded bytes are never modified, so
+// they are shared.
+func (v *Value) clone() *Value {
+	w := *v
+	if v.elems != nil {
+		w.elems = make([]*Value, len(v.elems))
+		for i, elem := range v.elems {
+			w.elems[i] = elem.clone()
+		}
+	}
+	return &w
+}
@@ -0,0 +1,148 @@
+package mcpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "gitlab.baidu.com/ksarch/gomcpack/mcpack"
+)
+
+func TestValueRoundTrip(t *testing.T) {
+	for _, tt := range marshalTests {
+		if tt.out == nil {
+			continue
+		}
+		var v Value
+		if err := Unmarshal(tt.out, &v); err != nil {
+			t.Fatal(err)
+		}
+		b, err := Marshal(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, tt.out) {
+			t.Errorf("got %#v, expect %#v", b, tt.out)
+		}
+	}
+}
+
+type HasV struct {
+	V Value
+}
+
+func TestValueField(t *testing.T) {
+	v, err := NewValue(map[string]int32{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := Marshal(map[string]map[string]int32{"V": {"a": 1}})
+	for _, in := range []interface{}{HasV{V: *v}, &HasV{V: *v}} {
+		b, err := Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expect) {
+			t.Errorf("%T: got %#v, expect %#v", in, b, expect)
+		}
+	}
+
+	// the zero Value encodes as NULL
+	b, err := Marshal(&HasV{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := Get(b, "V"); it.Type != MCPACKV2_NULL {
+		t.Errorf("got type %#x, expect NULL", it.Type)
+	}
+}
+
+func TestValueAccessors(t *testing.T) {
+	b, _ := Marshal(&V{F1: &U{Alphabet: "a-z"}, F2: 1, F3: Integer(1)})
+	var v Value
+	if err := Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Type() != MCPACKV2_OBJECT || v.Len() != 3 {
+		t.Fatalf("got type %#x with %d members", v.Type(), v.Len())
+	}
+	if got := v.Get("F1").Get("alpha").Str(); got != "a-z" {
+		t.Errorf("F1.alpha: got %q", got)
+	}
+	if f2 := v.Get("F2"); f2.Type() != MCPACKV2_INT32 {
+		t.Errorf("F2: got %#x", f2.Type())
+	} else if n, err := f2.Int(); n != 1 || err != nil {
+		t.Errorf("F2: got %d, %v", n, err)
+	}
+	if f3 := v.Index(2); f3.Key() != "F3" || f3.Type() != MCPACKV2_INT64 {
+		t.Errorf("Index(2): got %q %#x", f3.Key(), f3.Type())
+	}
+	if v.Get("missing").Index(3).Str() != "" {
+		t.Error("expected zero results through missing members")
+	}
+}
+
+func TestValueMutate(t *testing.T) {
+	b, _ := Marshal(&T{A: true, X: "x", Y: 1})
+	var v Value
+	if err := Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	x, _ := NewValue("changed")
+	if err := v.Set("X", x); err != nil {
+		t.Fatal(err)
+	}
+	v.Delete("A")
+	arr, _ := NewValue([]int32{})
+	arr.Append(v.Get("Y"))
+	v.Set("Z", arr)
+
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		A bool
+		X string
+		Y int
+		Z []int
+	}
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.A || got.X != "changed" || got.Y != 1 || len(got.Z) != 1 || got.Z[0] != 1 {
+		t.Errorf("got %#v", got)
+	}
+	if keys := v.Keys(); len(keys) != 3 || keys[0] != "X" || keys[2] != "Z" {
+		t.Errorf("got keys %q", keys)
+	}
+}
+
+func TestValueNestedEdit(t *testing.T) {
+	v, err := NewValue(map[string]interface{}{
+		"a": map[string]interface{}{"b": int32(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, _ := NewValue(int32(2))
+	if err := v.Get("a").Set("b", two); err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := NewValue(map[string]interface{}{"c": int32(3)})
+	v.Set("x", x)
+	four, _ := NewValue(int32(4))
+	x.Set("c", four)
+
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[string]int
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["a"]["b"] != 2 || got["x"]["c"] != 3 {
+		t.Errorf("got %v", got)
+	}
+}